@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// The stages of a bundle deployment recorded in its checkpoint. Lambda function deployments are
+// recorded as checkpointLambdaPrefix followed by the function name.
+const (
+	checkpointUploadAssets   = "upload_static_assets"
+	checkpointUploadManifest = "upload_manifest"
+	checkpointLambdaPrefix   = "deploy_lambda/"
+	checkpointRemoveOrphans  = "remove_orphaned_lambdas"
+)
+
+// bundleCheckpoint records the completed stages of a bundle deployment in the state bucket, so
+// that a deployment which failed resumes at the stage which failed. Checkpoints are not used in
+// dry run mode, where the stages which change the deployment are skipped.
+type bundleCheckpoint struct {
+	checkpoint *model.Checkpoint
+	enabled    bool
+	logger     appsutils.Logger
+}
+
+// loadCheckpoint returns the checkpoint of the bundle deployment. The checkpoint of a previous
+// attempt is discarded if ForceFullDeployment is true, or if the bundle was uploaded again since.
+func loadCheckpoint(bundle string, session *session.Session, dryRun bool, logger appsutils.Logger) (*bundleCheckpoint, error) {
+	checkpoint := &bundleCheckpoint{
+		checkpoint: &model.Checkpoint{Bundle: bundle, Environment: os.Getenv("Environment")},
+		enabled:    !dryRun,
+		logger:     logger,
+	}
+	if dryRun {
+		return checkpoint, nil
+	}
+
+	etag, err := awsTools.GetObjectETag(os.Getenv("AppsBundleBucketName"), bundle, session)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the bundle ETag")
+	}
+	checkpoint.checkpoint.ETag = etag
+
+	if os.Getenv("ForceFullDeployment") == "true" {
+		logger.Infof("Forcing a full deployment, discarding the checkpoint of previous attempts")
+		return checkpoint, nil
+	}
+
+	previous, err := awsTools.GetCheckpoint(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), bundle)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get the deployment checkpoint")
+	}
+	if previous == nil {
+		return checkpoint, nil
+	}
+	if previous.ETag != etag {
+		logger.Infof("Bundle was uploaded again since the previous attempt, discarding its checkpoint")
+		return checkpoint, nil
+	}
+
+	logger.Infof("Resuming the deployment after stages %v of the previous attempt", previous.Stages)
+	checkpoint.checkpoint = previous
+
+	return checkpoint, nil
+}
+
+// completed returns true if the stage was completed by a previous attempt.
+func (c *bundleCheckpoint) completed(stage string) bool {
+	if !c.enabled {
+		return false
+	}
+
+	for _, completed := range c.checkpoint.Stages {
+		if completed == stage {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lambda returns the result of the lambda function deployment completed by a previous attempt.
+func (c *bundleCheckpoint) lambda(name string) *model.LambdaResult {
+	for _, lambda := range c.checkpoint.Lambdas {
+		if lambda.Name == name {
+			return &lambda
+		}
+	}
+
+	return nil
+}
+
+// complete records the completion of the stage, with the result of the lambda function deployment
+// if the stage deployed one.
+func (c *bundleCheckpoint) complete(stage string, lambda *model.LambdaResult) error {
+	if !c.enabled || c.completed(stage) {
+		return nil
+	}
+
+	c.checkpoint.Stages = append(c.checkpoint.Stages, stage)
+	if lambda != nil {
+		c.checkpoint.Lambdas = append(c.checkpoint.Lambdas, *lambda)
+	}
+	c.checkpoint.UpdatedAt = time.Now()
+
+	err := awsTools.PutCheckpoint(os.Getenv("TerraformStateBucket"), c.checkpoint)
+	if err != nil {
+		return newStageError(categoryStorage, "Store checkpoint", errors.Wrapf(err, "failed to store the checkpoint of stage %s", stage))
+	}
+
+	return nil
+}
+
+// clear removes the checkpoint once the bundle is deployed.
+func (c *bundleCheckpoint) clear() error {
+	if !c.enabled {
+		return nil
+	}
+
+	err := awsTools.DeleteCheckpoint(os.Getenv("TerraformStateBucket"), c.checkpoint.Environment, c.checkpoint.Bundle)
+	if err != nil {
+		return newStageError(categoryStorage, "Remove checkpoint", errors.Wrap(err, "failed to remove the deployment checkpoint"))
+	}
+
+	return nil
+}
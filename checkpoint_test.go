@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	model "github.com/mattermost/mattermost-apps/model"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestBundleCheckpoint(t *testing.T) {
+	logger := appsutils.NewTestLogger()
+
+	t.Run("dry run", func(t *testing.T) {
+		checkpoint, err := loadCheckpoint("hello-world.zip", nil, true, logger)
+		require.NoError(t, err)
+
+		require.NoError(t, checkpoint.complete(checkpointUploadAssets, nil))
+		assert.False(t, checkpoint.completed(checkpointUploadAssets))
+		assert.Empty(t, checkpoint.checkpoint.Stages)
+		require.NoError(t, checkpoint.clear())
+	})
+
+	t.Run("resumed", func(t *testing.T) {
+		checkpoint := &bundleCheckpoint{
+			checkpoint: &model.Checkpoint{
+				Bundle: "hello-world.zip",
+				Stages: []string{checkpointUploadAssets, checkpointUploadManifest, checkpointLambdaPrefix + "hello"},
+				Lambdas: []model.LambdaResult{
+					{Name: "hello", Version: "3"},
+				},
+			},
+			enabled: true,
+			logger:  logger,
+		}
+
+		assert.True(t, checkpoint.completed(checkpointUploadManifest))
+		assert.True(t, checkpoint.completed(checkpointLambdaPrefix+"hello"))
+		assert.False(t, checkpoint.completed(checkpointLambdaPrefix+"world"))
+		assert.False(t, checkpoint.completed(checkpointRemoveOrphans))
+
+		// Completing a stage completed by the previous attempt stores nothing.
+		require.NoError(t, checkpoint.complete(checkpointUploadAssets, nil))
+		assert.Len(t, checkpoint.checkpoint.Stages, 3)
+
+		require.NotNil(t, checkpoint.lambda("hello"))
+		assert.Equal(t, "3", checkpoint.lambda("hello").Version)
+		assert.Nil(t, checkpoint.lambda("world"))
+	})
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+)
+
+const (
+	// commandPath is the path of the /apps-deploy slash command endpoint.
+	commandPath = "/mattermost/command"
+	// commandTrigger is the trigger word the slash command is registered with in Mattermost.
+	commandTrigger = "/apps-deploy"
+	// commandStatusRuns is the number of recent runs shown by the status subcommand.
+	commandStatusRuns = 10
+)
+
+// slashCommand is a subcommand of the /apps-deploy slash command.
+type slashCommand struct {
+	usage string
+	// deployer is true if the subcommand changes deployments, and is restricted to the users in
+	// SlashCommandDeployers.
+	deployer bool
+	// minArgs and maxArgs are the number of arguments accepted by the subcommand.
+	minArgs int
+	maxArgs int
+	handle  func(s *apiServer, request *commandRequest) *mmmodel.CommandResponse
+}
+
+var slashCommands = map[string]slashCommand{
+	"list":     {usage: "list", handle: (*apiServer).commandList},
+	"status":   {usage: "status [run id]", maxArgs: 1, handle: (*apiServer).commandStatus},
+	"plan":     {usage: "plan <bundle>", minArgs: 1, maxArgs: 1, handle: (*apiServer).commandPlan},
+	"deploy":   {usage: "deploy <bundle>", minArgs: 1, maxArgs: 1, deployer: true, handle: (*apiServer).commandDeploy},
+	"rollback": {usage: "rollback <app>", minArgs: 1, maxArgs: 1, deployer: true, handle: (*apiServer).commandRollback},
+}
+
+// commandRequest is a slash command request sent by Mattermost.
+type commandRequest struct {
+	UserID   string
+	UserName string
+	Args     []string
+}
+
+// handleCommand handles the /apps-deploy slash command. Requests are verified with the
+// SlashCommandToken of the command, and users are authorized per subcommand: the users in
+// SlashCommandViewers may list apps, check the status of runs and plan bundles, and the users in
+// SlashCommandDeployers may also deploy bundles and roll apps back. Users are listed by user ID,
+// comma separated; usernames are not accepted since they can be changed by their users.
+func (s *apiServer) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, errors.Wrap(err, "invalid slash command request"))
+		return
+	}
+
+	token := os.Getenv("SlashCommandToken")
+	if token == "" || subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(token)) != 1 {
+		writeAPIError(w, http.StatusUnauthorized, errors.New("invalid slash command token"))
+		return
+	}
+
+	request := &commandRequest{
+		UserID:   r.PostForm.Get("user_id"),
+		UserName: r.PostForm.Get("user_name"),
+		Args:     strings.Fields(r.PostForm.Get("text")),
+	}
+	writeJSON(w, http.StatusOK, s.executeCommand(request))
+}
+
+func (s *apiServer) executeCommand(request *commandRequest) *mmmodel.CommandResponse {
+	if len(request.Args) == 0 {
+		return commandHelp()
+	}
+
+	command, ok := slashCommands[request.Args[0]]
+	if !ok || len(request.Args)-1 < command.minArgs || len(request.Args)-1 > command.maxArgs {
+		return commandHelp()
+	}
+
+	allowed := isCommandUser(os.Getenv("SlashCommandDeployers"), request)
+	if !command.deployer {
+		allowed = allowed || isCommandUser(os.Getenv("SlashCommandViewers"), request)
+	}
+	if !allowed {
+		s.logger.With("user_id", request.UserID, "command", request.Args[0]).Warnf("Unauthorized slash command")
+		return newCommandResponse(colorError, fmt.Sprintf("You are not allowed to run `%s %s`.", commandTrigger, request.Args[0]), nil)
+	}
+
+	return command.handle(s, request)
+}
+
+// isCommandUser returns true if the user is in the comma separated list of user IDs.
+func isCommandUser(users string, request *commandRequest) bool {
+	for _, user := range strings.Split(users, ",") {
+		user = strings.TrimSpace(user)
+		if user != "" && user == request.UserID {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *apiServer) commandList(request *commandRequest) *mmmodel.CommandResponse {
+	records, err := awsTools.ListDeploymentRecords(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"))
+	if err != nil {
+		s.logger.WithError(err).Errorf("Failed to list deployed apps")
+		return newCommandResponse(colorError, "Failed to list the deployed apps.", nil)
+	}
+	if len(records) == 0 {
+		return newCommandResponse(colorPlan, fmt.Sprintf("No apps are deployed in %s.", os.Getenv("Environment")), nil)
+	}
+
+	var fields []*mmmodel.SlackAttachmentField
+	for _, record := range records {
+		fields = append(fields, &mmmodel.SlackAttachmentField{
+			Title: record.AppID,
+			Value: fmt.Sprintf("%s from `%s`, deployed %s", record.Version, record.Bundle, record.DeployedAt.Format("2006-01-02 15:04 MST")),
+			Short: true,
+		})
+	}
+
+	return newCommandResponse(colorPlan, fmt.Sprintf("Apps deployed in %s", os.Getenv("Environment")), fields)
+}
+
+func (s *apiServer) commandStatus(request *commandRequest) *mmmodel.CommandResponse {
+	runs := s.store.list()
+	if len(request.Args) > 1 {
+		run := s.store.get(request.Args[1])
+		if run == nil {
+			return newCommandResponse(colorError, fmt.Sprintf("Run `%s` not found.", request.Args[1]), nil)
+		}
+		runs = []apiRun{*run}
+	}
+	if len(runs) == 0 {
+		return newCommandResponse(colorPlan, "No deployment runs yet.", nil)
+	}
+	if len(runs) > commandStatusRuns {
+		runs = runs[:commandStatusRuns]
+	}
+
+	var fields []*mmmodel.SlackAttachmentField
+	for _, run := range runs {
+		target := run.Bundle
+		if target == "" {
+			target = run.AppID
+		}
+		value := fmt.Sprintf("%s of `%s`, requested %s", run.Status, target, run.CreatedAt.Format("2006-01-02 15:04 MST"))
+		if run.RequestedBy != "" {
+			value = fmt.Sprintf("%s by @%s", value, run.RequestedBy)
+		}
+		if run.Error != "" {
+			value = fmt.Sprintf("%s\n%s", value, errorExcerpt(errors.New(run.Error)))
+		}
+		fields = append(fields, &mmmodel.SlackAttachmentField{
+			Title: fmt.Sprintf("%s `%s`", run.Type, run.ID),
+			Value: value,
+		})
+	}
+
+	return newCommandResponse(colorPlan, "Deployment runs", fields)
+}
+
+func (s *apiServer) commandPlan(request *commandRequest) *mmmodel.CommandResponse {
+	return s.queueCommandRun(request, &apiRun{Type: runTypePlan, Bundle: request.Args[1], DryRun: true})
+}
+
+func (s *apiServer) commandDeploy(request *commandRequest) *mmmodel.CommandResponse {
+	return s.queueCommandRun(request, &apiRun{Type: runTypeDeploy, Bundle: request.Args[1]})
+}
+
+func (s *apiServer) commandRollback(request *commandRequest) *mmmodel.CommandResponse {
+	return s.queueCommandRun(request, &apiRun{Type: runTypeRollback, AppID: request.Args[1]})
+}
+
+func (s *apiServer) queueCommandRun(request *commandRequest, run *apiRun) *mmmodel.CommandResponse {
+	if run.Bundle != "" && (!strings.HasSuffix(run.Bundle, ".zip") || strings.Contains(run.Bundle, "/")) {
+		return newCommandResponse(colorError, fmt.Sprintf("`%s` is not a bundle of the bundle bucket.", run.Bundle), nil)
+	}
+
+	run.RequestedBy = request.UserName
+	err := s.worker.enqueue(run)
+	if err != nil {
+		s.logger.WithError(err).Errorf("Failed to queue run")
+		return newCommandResponse(colorError, "Failed to queue the run.", nil)
+	}
+	s.logger.With("run_id", run.ID, "run_type", run.Type, "user_id", request.UserID).Infof("Queued run from slash command")
+
+	target := run.Bundle
+	if target == "" {
+		target = run.AppID
+	}
+	response := newCommandResponse(colorDeployment, fmt.Sprintf("Queued %s of `%s` in %s", run.Type, target, os.Getenv("Environment")), []*mmmodel.SlackAttachmentField{
+		{Title: "Run", Value: fmt.Sprintf("`%s`", run.ID), Short: true},
+		{Title: "Requested By", Value: "@" + request.UserName, Short: true},
+	})
+	response.ResponseType = mmmodel.COMMAND_RESPONSE_TYPE_IN_CHANNEL
+
+	return response
+}
+
+func commandHelp() *mmmodel.CommandResponse {
+	var usages []string
+	for _, name := range []string{"list", "status", "plan", "deploy", "rollback"} {
+		usages = append(usages, fmt.Sprintf("`%s %s`", commandTrigger, slashCommands[name].usage))
+	}
+
+	return newCommandResponse(colorPlan, "Usage:\n"+strings.Join(usages, "\n"), nil)
+}
+
+// newCommandResponse returns an ephemeral slash command response with a single attachment, posted
+// with the username and icon of the notifications.
+func newCommandResponse(color, text string, fields []*mmmodel.SlackAttachmentField) *mmmodel.CommandResponse {
+	data := newTemplateData("")
+
+	return &mmmodel.CommandResponse{
+		ResponseType: mmmodel.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Username:     data.Username,
+		IconURL:      data.IconURL,
+		Attachments: []*mmmodel.SlackAttachment{{
+			Color:  color,
+			Text:   text,
+			Fields: append(fields, &mmmodel.SlackAttachmentField{Title: "Environment", Value: data.Environment, Short: true}),
+		}},
+	}
+}
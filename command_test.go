@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCommand(t *testing.T) {
+	t.Setenv("SlashCommandToken", "command-token")
+	t.Setenv("SlashCommandViewers", "viewer-id")
+	t.Setenv("SlashCommandDeployers", "other-id, deployer-id")
+	t.Setenv("Environment", "test")
+
+	server := newTestAPIServer(t, t.TempDir())
+	handler := server.handler()
+
+	command := func(token, userName, text string) (int, *mmmodel.CommandResponse) {
+		form := url.Values{
+			"token":     {token},
+			"user_id":   {userName + "-id"},
+			"user_name": {userName},
+			"text":      {text},
+		}
+		req := httptest.NewRequest(http.MethodPost, commandPath, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		var response mmmodel.CommandResponse
+		if recorder.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		}
+		return recorder.Code, &response
+	}
+
+	t.Run("invalid token", func(t *testing.T) {
+		code, _ := command("wrong", "deployer", "status")
+		assert.Equal(t, http.StatusUnauthorized, code)
+	})
+
+	t.Run("help", func(t *testing.T) {
+		code, response := command("command-token", "viewer", "unknown")
+		require.Equal(t, http.StatusOK, code)
+		assert.Equal(t, mmmodel.COMMAND_RESPONSE_TYPE_EPHEMERAL, response.ResponseType)
+		assert.Contains(t, response.Attachments[0].Text, "/apps-deploy deploy <bundle>")
+	})
+
+	t.Run("unauthorized user", func(t *testing.T) {
+		_, response := command("command-token", "stranger", "status")
+		assert.Equal(t, colorError, response.Attachments[0].Color)
+
+		_, response = command("command-token", "viewer", "deploy hello-world.zip")
+		assert.Equal(t, colorError, response.Attachments[0].Color)
+		assert.Contains(t, response.Attachments[0].Text, "not allowed")
+	})
+
+	t.Run("username is not authorized", func(t *testing.T) {
+		t.Setenv("SlashCommandViewers", "viewer")
+		_, response := command("command-token", "viewer", "status")
+		assert.Equal(t, colorError, response.Attachments[0].Color)
+	})
+
+	t.Run("viewer plans", func(t *testing.T) {
+		_, response := command("command-token", "viewer", "plan hello-world.zip")
+		assert.Equal(t, colorDeployment, response.Attachments[0].Color)
+		assert.Equal(t, "Queued plan of `hello-world.zip` in test", response.Attachments[0].Text)
+	})
+
+	t.Run("deployer deploys", func(t *testing.T) {
+		_, response := command("command-token", "deployer", "deploy hello-world.zip")
+		assert.Equal(t, mmmodel.COMMAND_RESPONSE_TYPE_IN_CHANNEL, response.ResponseType)
+		assert.Equal(t, colorDeployment, response.Attachments[0].Color)
+		assert.Equal(t, "@deployer", response.Attachments[0].Fields[1].Value)
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		_, response := command("command-token", "deployer", "deploy ../hello-world.zip")
+		assert.Equal(t, colorError, response.Attachments[0].Color)
+	})
+
+	t.Run("status", func(t *testing.T) {
+		_, response := command("command-token", "viewer", "status")
+		fields := response.Attachments[0].Fields
+		require.Len(t, fields, 3)
+		assert.Contains(t, fields[0].Value, "queued of `hello-world.zip`")
+		assert.Contains(t, fields[0].Value, "by @deployer")
+		assert.Equal(t, "Environment", fields[2].Title)
+	})
+
+	t.Run("status of a run", func(t *testing.T) {
+		runs := server.store.list()
+		require.NotEmpty(t, runs)
+
+		_, response := command("command-token", "viewer", "status "+runs[0].ID)
+		fields := response.Attachments[0].Fields
+		require.Len(t, fields, 2)
+		assert.Contains(t, fields[0].Title, runs[0].ID)
+
+		_, response = command("command-token", "viewer", "status unknown-run")
+		assert.Equal(t, colorError, response.Attachments[0].Color)
+		assert.Contains(t, response.Attachments[0].Text, "not found")
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+
+	exechelper "github.com/mattermost/mattermost-apps/internal/tools/exechelper"
+)
+
+// stderrTailLines is the number of STDERR lines attached to a deployment error.
+const stderrTailLines = 20
+
+// errorCategory classifies deployment failures by their likely cause.
+type errorCategory string
+
+const (
+	categoryConfiguration errorCategory = "configuration"
+	categoryCredentials   errorCategory = "credentials"
+	categoryBundleInvalid errorCategory = "bundle_invalid"
+	categoryStorage       errorCategory = "storage"
+	categoryTerraform     errorCategory = "terraform"
+	categoryRelease       errorCategory = "release"
+	categoryNotification  errorCategory = "notification"
+	categoryLock          errorCategory = "lock"
+	categoryUnknown       errorCategory = "unknown"
+)
+
+var categoryHints = map[errorCategory]string{
+	categoryConfiguration: "Check the deployer environment variables.",
+	categoryCredentials:   "Check that the deployer IAM role can be assumed and grants access to the bundle, static and state buckets.",
+	categoryBundleInvalid: "Check that the bundle is a valid Mattermost Apps AWS bundle with a manifest and every lambda function listed in it.",
+	categoryStorage:       "Check that the S3 buckets exist and the objects are readable and writable by the deployer.",
+	categoryTerraform:     "Check the Terraform output below and the state of the lambda function in the state bucket.",
+	categoryRelease:       "Check the lambda alias, the canary errors and the smoke test response of the new version.",
+	categoryNotification:  "Check the notification sinks configuration and that the webhooks are reachable.",
+	categoryLock:          "Check that another deployer is not running, and that the lock table is writable by the deployer.",
+	categoryUnknown:       "Check the deployer logs for details.",
+}
+
+// credentialErrorCodes are the AWS error codes of authentication and authorization failures.
+var credentialErrorCodes = map[string]bool{
+	"AccessDenied":                true,
+	"AccessDeniedException":       true,
+	"ExpiredToken":                true,
+	"InvalidAccessKeyId":          true,
+	"InvalidClientTokenId":        true,
+	"NoCredentialProviders":       true,
+	"SignatureDoesNotMatch":       true,
+	"UnrecognizedClientException": true,
+}
+
+// credentialStderrMarkers mark Terraform failures caused by missing or invalid credentials.
+var credentialStderrMarkers = []string{
+	"No valid credential sources found",
+	"AccessDenied",
+	"ExpiredToken",
+	"InvalidClientTokenId",
+}
+
+// deploymentError covers a deployment failure with the context needed to act on it.
+type deploymentError struct {
+	Category errorCategory
+	Stage    string
+	Bundle   string
+	Lambda   string
+	Stderr   string
+	err      error
+}
+
+// Error returns the error message.
+func (e *deploymentError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *deploymentError) Unwrap() error {
+	return e.err
+}
+
+// details returns the context of the error shown in failure alerts.
+func (e *deploymentError) details() *errorDetails {
+	return &errorDetails{
+		Category: string(e.Category),
+		Stage:    e.Stage,
+		Bundle:   e.Bundle,
+		Lambda:   e.Lambda,
+		Hint:     e.Hint(),
+		Stderr:   e.Stderr,
+	}
+}
+
+// Hint returns a suggestion of what to check to fix the failure.
+func (e *deploymentError) Hint() string {
+	return categoryHints[e.Category]
+}
+
+// errorDetails covers the context of a deployment failure sent with error notifications.
+type errorDetails struct {
+	Category string `json:"category"`
+	Stage    string `json:"stage,omitempty"`
+	Bundle   string `json:"bundle,omitempty"`
+	Lambda   string `json:"lambda,omitempty"`
+	Hint     string `json:"hint,omitempty"`
+	Stderr   string `json:"stderr,omitempty"`
+}
+
+// newStageError classifies the error of a deployment stage. The category is overridden when the
+// error was caused by invalid credentials, and the tail of the STDERR output of a failed command is
+// attached to the error.
+func newStageError(category errorCategory, stage string, err error) error {
+	return newLambdaStageError(category, stage, "", err)
+}
+
+// newLambdaStageError classifies the error of a deployment stage of a lambda function.
+func newLambdaStageError(category errorCategory, stage, lambda string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	stderr := stderrTail(err)
+	if isCredentialError(err, stderr) {
+		category = categoryCredentials
+	}
+
+	return &deploymentError{
+		Category: category,
+		Stage:    stage,
+		Lambda:   lambda,
+		Stderr:   stderr,
+		err:      err,
+	}
+}
+
+// classifyBundleError sets the bundle of the classified error in the error chain, classifying it
+// as unknown if it was not classified yet.
+func classifyBundleError(err error, bundle string) error {
+	if err == nil {
+		return nil
+	}
+
+	var deployErr *deploymentError
+	if !errors.As(err, &deployErr) {
+		deployErr = newStageError(categoryUnknown, "", err).(*deploymentError)
+		err = deployErr
+	}
+	deployErr.Bundle = bundle
+
+	return err
+}
+
+// getDeploymentError returns the classified error in the error chain, if any.
+func getDeploymentError(err error) *deploymentError {
+	var deployErr *deploymentError
+	if errors.As(err, &deployErr) {
+		return deployErr
+	}
+
+	return nil
+}
+
+// stderrTail returns the last stderrTailLines lines of the STDERR output of a failed command in
+// the error chain.
+func stderrTail(err error) string {
+	var stderr []byte
+
+	var runErr *exechelper.Error
+	var exitErr *exec.ExitError
+	switch {
+	case errors.As(err, &runErr):
+		stderr = runErr.Stderr
+	case errors.As(err, &exitErr):
+		stderr = exitErr.Stderr
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(stderr)), "\n")
+	if len(lines) > stderrTailLines {
+		lines = lines[len(lines)-stderrTailLines:]
+	}
+
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func isCredentialError(err error, stderr string) bool {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) && credentialErrorCodes[awsErr.Code()] {
+		return true
+	}
+
+	for _, marker := range credentialStderrMarkers {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	exechelper "github.com/mattermost/mattermost-apps/internal/tools/exechelper"
+)
+
+func TestNewStageError(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		assert.NoError(t, newStageError(categoryStorage, "Download bundle", nil))
+	})
+
+	t.Run("stderr tail", func(t *testing.T) {
+		var lines []string
+		for i := 1; i <= 30; i++ {
+			lines = append(lines, fmt.Sprintf("line %d", i))
+		}
+		runErr := &exechelper.Error{
+			Err:    errors.New("failed invocation: exit status 1"),
+			Stderr: []byte(strings.Join(lines, "\n") + "\n"),
+		}
+
+		err := newLambdaStageError(categoryTerraform, "Terraform apply", "hello-world_v1-0-0_go-function", errors.Wrap(runErr, "failed to run Terraform apply"))
+		err = classifyBundleError(errors.Wrap(err, "failed to deploy lambda functions for bundle"), "hello-world.zip")
+
+		deployErr := getDeploymentError(err)
+		require.NotNil(t, deployErr)
+		assert.Equal(t, categoryTerraform, deployErr.Category)
+		assert.Equal(t, "Terraform apply", deployErr.Stage)
+		assert.Equal(t, "hello-world.zip", deployErr.Bundle)
+		assert.Equal(t, "hello-world_v1-0-0_go-function", deployErr.Lambda)
+		assert.Equal(t, strings.Join(lines[10:], "\n"), deployErr.Stderr)
+		assert.Equal(t, "failed to deploy lambda functions for bundle: failed to run Terraform apply: failed invocation: exit status 1", err.Error())
+	})
+
+	t.Run("aws credentials", func(t *testing.T) {
+		err := newStageError(categoryStorage, "Download bundle", awserr.New("ExpiredToken", "the security token has expired", nil))
+		assert.Equal(t, categoryCredentials, getDeploymentError(err).Category)
+	})
+
+	t.Run("terraform credentials", func(t *testing.T) {
+		runErr := &exechelper.Error{
+			Err:    errors.New("failed invocation: exit status 1"),
+			Stderr: []byte("Error: No valid credential sources found for AWS Provider."),
+		}
+		err := newStageError(categoryTerraform, "Terraform init", runErr)
+		assert.Equal(t, categoryCredentials, getDeploymentError(err).Category)
+	})
+
+	t.Run("unclassified", func(t *testing.T) {
+		err := classifyBundleError(errors.New("unexpected"), "hello-world.zip")
+		deployErr := getDeploymentError(err)
+		require.NotNil(t, deployErr)
+		assert.Equal(t, categoryUnknown, deployErr.Category)
+		assert.Equal(t, "hello-world.zip", deployErr.Bundle)
+		assert.NotEmpty(t, deployErr.Hint())
+	})
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	"github.com/mattermost/mattermost-apps/internal/tools/queue"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// fileQueuePrefix selects a local directory queue instead of SQS in EventQueueURL.
+	fileQueuePrefix = "file://"
+	// defaultEventPollInterval is the interval the file queue is polled at if EventPollInterval is not set.
+	defaultEventPollInterval = 20 * time.Second
+	// defaultEventVisibilityTimeout is the time a message is hidden from other consumers at once
+	// while it is processed, if EventVisibilityTimeout is not set.
+	defaultEventVisibilityTimeout = 5 * time.Minute
+	// defaultEventRetryDelay is the delay before a failed message is delivered again for the first
+	// time, if EventRetryDelay is not set.
+	defaultEventRetryDelay = 30 * time.Second
+	// maxEventRetryDelay bounds the delay before a failed message is delivered again, which doubles
+	// at every delivery.
+	maxEventRetryDelay = 15 * time.Minute
+)
+
+// unsafeReportChars are the characters of message IDs replaced in report file names.
+var unsafeReportChars = regexp.MustCompile(`[^A-Za-z0-9_-]`)
+
+// eventTimeouts covers the timing of the processing of bundle upload events.
+type eventTimeouts struct {
+	// visibility is the time a message is hidden from other consumers at once while it is
+	// processed. It is renewed every third of it until the message is processed.
+	visibility time.Duration
+	// retryDelay is the delay before a failed message is delivered again for the first time.
+	retryDelay time.Duration
+}
+
+// getEventTimeouts returns the configured EventVisibilityTimeout and EventRetryDelay.
+func getEventTimeouts() (eventTimeouts, error) {
+	timeouts := eventTimeouts{
+		visibility: defaultEventVisibilityTimeout,
+		retryDelay: defaultEventRetryDelay,
+	}
+
+	var err error
+	if os.Getenv("EventVisibilityTimeout") != "" {
+		timeouts.visibility, err = time.ParseDuration(os.Getenv("EventVisibilityTimeout"))
+		if err != nil {
+			return timeouts, errors.Wrap(err, "invalid EventVisibilityTimeout")
+		}
+		if timeouts.visibility < time.Second {
+			return timeouts, errors.Errorf("EventVisibilityTimeout must be at least 1s, got %s", timeouts.visibility)
+		}
+	}
+	if os.Getenv("EventRetryDelay") != "" {
+		timeouts.retryDelay, err = time.ParseDuration(os.Getenv("EventRetryDelay"))
+		if err != nil {
+			return timeouts, errors.Wrap(err, "invalid EventRetryDelay")
+		}
+	}
+
+	return timeouts, nil
+}
+
+// redeliveryDelay returns the delay before a failed message received the given number of times is
+// delivered again, doubling at every delivery up to maxEventRetryDelay.
+func (t eventTimeouts) redeliveryDelay(receiveCount int) time.Duration {
+	delay := t.retryDelay
+	for i := 1; i < receiveCount && delay < maxEventRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxEventRetryDelay {
+		delay = maxEventRetryDelay
+	}
+
+	return delay
+}
+
+// runEvents consumes the S3 ObjectCreated events of the bundle bucket from EventQueueURL, and
+// deploys every uploaded bundle as soon as its event is received. Messages are deleted once their
+// bundles are deployed, and are otherwise left in the queue to be delivered again after
+// EventRetryDelay, doubled at every delivery, or moved to the dead-letter queue. Messages are kept
+// hidden from other consumers while their bundles are deployed. It runs until it is interrupted.
+func runEvents(logger appsutils.Logger) {
+	err := initTracing(context.Background())
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to initialize tracing, traces will not be exported")
+	}
+	_, setupSpan := startSpan(context.Background(), "deployer setup")
+
+	session := setup(newRunReport(), setupSpan, logger)
+
+	eventQueue, err := newEventQueue(os.Getenv("EventQueueURL"), session)
+	if err != nil {
+		err = newStageError(categoryConfiguration, "Connect to event queue", err)
+		logger.WithError(err).Errorf("Failed to connect to the event queue")
+		exitWithSetupError(err, "Mattermost apps deployer failed to connect to the event queue.", newRunReport(), setupSpan, logger)
+	}
+
+	timeouts, err := getEventTimeouts()
+	if err != nil {
+		err = newStageError(categoryConfiguration, "Check event timeouts", err)
+		logger.WithError(err).Errorf("Invalid event timeouts")
+		exitWithSetupError(err, "Mattermost apps deployer event timeouts are invalid.", newRunReport(), setupSpan, logger)
+	}
+	endSpan(setupSpan, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Infof("Waiting for bundle upload events")
+	consumeEvents(ctx, eventQueue, session, timeouts, logger)
+
+	logger.Infof("Stopped consuming bundle upload events")
+	shutdownTracing(logger)
+}
+
+// newEventQueue returns the SQS queue with the given URL, or the local directory queue if the URL
+// starts with file://.
+func newEventQueue(url string, session *session.Session) (queue.Queue, error) {
+	if url == "" {
+		return nil, errors.New("EventQueueURL was not set")
+	}
+	if !strings.HasPrefix(url, fileQueuePrefix) {
+		return queue.NewSQSQueue(url, session), nil
+	}
+
+	pollInterval := defaultEventPollInterval
+	if os.Getenv("EventPollInterval") != "" {
+		var err error
+		pollInterval, err = time.ParseDuration(os.Getenv("EventPollInterval"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid EventPollInterval")
+		}
+	}
+
+	return queue.NewFileQueue(strings.TrimPrefix(url, fileQueuePrefix), pollInterval)
+}
+
+// consumeEvents processes the messages of the queue until the context is done.
+func consumeEvents(ctx context.Context, eventQueue queue.Queue, session *session.Session, timeouts eventTimeouts, logger appsutils.Logger) {
+	for ctx.Err() == nil {
+		messages, err := eventQueue.Receive(ctx)
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to receive bundle upload events")
+			select {
+			case <-ctx.Done():
+			case <-time.After(defaultEventPollInterval):
+			}
+			continue
+		}
+
+		for _, message := range messages {
+			if ctx.Err() != nil {
+				return
+			}
+			processEvent(ctx, eventQueue, message, session, timeouts, logger.With("message_id", message.ID))
+		}
+	}
+}
+
+// processEvent deploys the bundles uploaded according to the message, and deletes the message if
+// all of them were deployed. Otherwise the message is delivered again after the redelivery delay.
+func processEvent(ctx context.Context, eventQueue queue.Queue, message queue.Message, session *session.Session, timeouts eventTimeouts, logger appsutils.Logger) {
+	stopHeartbeat := keepMessageHidden(ctx, eventQueue, message, timeouts.visibility, logger)
+	eventCtx, span := startSpan(ctx, "process event", attribute.String("message_id", message.ID))
+	summary := newRunSummary()
+	report := newRunReport()
+	err := deployEventBundles(eventCtx, message, session, summary, report, logger)
+	endSpan(span, err)
+	stopHeartbeat()
+
+	summary.Duration = time.Since(summary.StartedAt)
+	recordSummaryMetrics(summary)
+	if !perBundleNotifications() && !summary.isEmpty() {
+		notifyErr := sendSummaryNotification(summary)
+		if notifyErr != nil {
+			logger.WithError(notifyErr).Errorf("Failed to send Mattermost summary notification")
+		}
+	}
+	exportErr := exportMetrics()
+	if exportErr != nil {
+		logger.WithError(exportErr).Errorf("Failed to export metrics")
+	}
+
+	report.finish(summary.exitCode(), err)
+	reportErr := report.writeTo(eventReportPath(message.ID))
+	if reportErr != nil {
+		logger.WithError(reportErr).Errorf("Failed to write run report")
+	}
+
+	if err != nil {
+		delay := timeouts.redeliveryDelay(message.ReceiveCount)
+		logger.WithError(err).Errorf("Failed to process bundle upload event, leaving it for redelivery in %s", delay)
+		err = eventQueue.ChangeVisibility(context.Background(), message, delay)
+		if err != nil {
+			logger.WithError(err).Errorf("Failed to delay the redelivery of bundle upload event")
+		}
+		return
+	}
+
+	// The message is deleted even if the deployer is being stopped, as its bundles were deployed.
+	err = eventQueue.Delete(context.Background(), message)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to delete bundle upload event")
+	}
+}
+
+// keepMessageHidden hides the message from other consumers for the visibility timeout, renewed
+// every third of it, until the returned function is called.
+func keepMessageHidden(ctx context.Context, eventQueue queue.Queue, message queue.Message, timeout time.Duration, logger appsutils.Logger) func() {
+	heartbeatCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(timeout / 3)
+		defer ticker.Stop()
+		for {
+			err := eventQueue.ChangeVisibility(heartbeatCtx, message, timeout)
+			if err != nil && heartbeatCtx.Err() == nil {
+				logger.WithError(err).Warnf("Failed to extend the visibility timeout of bundle upload event")
+			}
+
+			select {
+			case <-heartbeatCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
+
+// eventReportPath returns the path of the run report of the event, RunReportPath suffixed with the
+// message ID, so that the report of every event is kept.
+func eventReportPath(messageID string) string {
+	reportPath := os.Getenv("RunReportPath")
+	if reportPath == "" {
+		return ""
+	}
+
+	extension := filepath.Ext(reportPath)
+	return strings.TrimSuffix(reportPath, extension) + "-" + unsafeReportChars.ReplaceAllString(messageID, "_") + extension
+}
+
+// deployEventBundles deploys the bundles of the bundle bucket created according to the message.
+// Bundles which are already deployed are skipped, so that redelivered messages are harmless, and
+// bundles claimed by another deployer are left for redelivery.
+func deployEventBundles(ctx context.Context, message queue.Message, session *session.Session, summary *runSummary, report *runReport, logger appsutils.Logger) error {
+	objects, err := awsTools.ParseS3CreatedEvent(message.Body)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, object := range objects {
+		if object.Bucket != os.Getenv("AppsBundleBucketName") || !strings.HasSuffix(object.Key, ".zip") {
+			logger.Infof("Ignoring object %s of bucket %s", object.Key, object.Bucket)
+			continue
+		}
+		bundlesTotal.WithLabelValues(bundleStatusDiscovered).Inc()
+
+		err = deployBundle(ctx, object.Key, session, report.DryRun, true, summary, report, logger)
+		if err != nil {
+			failed = append(failed, object.Key)
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("failed to deploy bundles %s", strings.Join(failed, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-apps/internal/tools/queue"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestNewEventQueue(t *testing.T) {
+	t.Run("not set", func(t *testing.T) {
+		_, err := newEventQueue("", nil)
+		assert.Error(t, err)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		eventQueue, err := newEventQueue("file://"+filepath.Join(t.TempDir(), "events"), nil)
+		require.NoError(t, err)
+		assert.IsType(t, &queue.FileQueue{}, eventQueue)
+	})
+
+	t.Run("invalid poll interval", func(t *testing.T) {
+		t.Setenv("EventPollInterval", "soon")
+		_, err := newEventQueue("file://"+t.TempDir(), nil)
+		assert.Error(t, err)
+	})
+}
+
+func TestProcessEvent(t *testing.T) {
+	t.Setenv("AppsBundleBucketName", "apps-bundles")
+	logger := appsutils.NewTestLogger()
+
+	eventQueue := queue.NewMemoryQueue()
+	eventQueue.Send(queue.Message{ID: "ignored", Body: []byte(`{"Records":[{"eventName":"ObjectCreated:Put","s3":{"bucket":{"name":"other-bucket"},"object":{"key":"hello-world.zip"}}}]}`)})
+	eventQueue.Send(queue.Message{ID: "test-event", Body: []byte(`{"Service":"Amazon S3","Event":"s3:TestEvent"}`)})
+	eventQueue.Send(queue.Message{ID: "invalid", Body: []byte("not json")})
+
+	timeouts := eventTimeouts{visibility: time.Minute, retryDelay: time.Hour}
+	messages, err := eventQueue.Receive(context.Background())
+	require.NoError(t, err)
+	for _, message := range messages {
+		processEvent(context.Background(), eventQueue, message, nil, timeouts, logger)
+	}
+
+	// Only the invalid message is left for redelivery, after the retry delay.
+	assert.Equal(t, 1, eventQueue.Len())
+	messages, err = eventQueue.Receive(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestEventTimeouts(t *testing.T) {
+	t.Setenv("EventVisibilityTimeout", "")
+	t.Setenv("EventRetryDelay", "")
+	timeouts, err := getEventTimeouts()
+	require.NoError(t, err)
+	assert.Equal(t, eventTimeouts{visibility: defaultEventVisibilityTimeout, retryDelay: defaultEventRetryDelay}, timeouts)
+
+	assert.Equal(t, defaultEventRetryDelay, timeouts.redeliveryDelay(1))
+	assert.Equal(t, 4*defaultEventRetryDelay, timeouts.redeliveryDelay(3))
+	assert.Equal(t, maxEventRetryDelay, timeouts.redeliveryDelay(100))
+
+	t.Setenv("EventVisibilityTimeout", "0s")
+	_, err = getEventTimeouts()
+	assert.Error(t, err)
+}
+
+func TestEventReportPath(t *testing.T) {
+	t.Setenv("RunReportPath", "")
+	assert.Empty(t, eventReportPath("message"))
+
+	t.Setenv("RunReportPath", "/reports/run.json")
+	assert.Equal(t, "/reports/run-a1b2_c3.json", eventReportPath("a1b2/c3"))
+}
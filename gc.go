@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/hashicorp/go-version"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	"github.com/mattermost/mattermost-apps/internal/tools/lock"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// defaultGCKeepBundles is the number of deployed bundles kept per app if GCKeepBundles is not
+	// set.
+	defaultGCKeepBundles = 5
+	// staticAssetsPrefix and manifestsPrefix are the prefixes of the static bucket objects managed
+	// by the deployer. Other objects are never collected.
+	staticAssetsPrefix = "static/"
+	manifestsPrefix    = "manifests/"
+)
+
+const (
+	bundleActionArchive = "archive"
+	bundleActionDelete  = "delete"
+)
+
+// gcReport is the machine-readable result of a garbage collection, written to RunReportPath.
+type gcReport struct {
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	DryRun       bool      `json:"dry_run"`
+	Environments []string  `json:"environments"`
+	ExitCode     int       `json:"exit_code"`
+	// StaticAssets are the static assets and manifests referenced by no deployed app version.
+	StaticAssets []awsTools.ObjectInfo `json:"static_assets"`
+	StaticBytes  int64                 `json:"static_bytes"`
+	// Bundles are the deployed bundles beyond the last GCKeepBundles of their app.
+	Bundles      []gcBundle `json:"bundles"`
+	BundleAction string     `json:"bundle_action"`
+	// UntrackedBundles are the deployed bundles without app tags, deployed before bundles were
+	// tagged with their app, which are never collected.
+	UntrackedBundles []string `json:"untracked_bundles,omitempty"`
+	Errors           []string `json:"errors,omitempty"`
+}
+
+// gcBundle covers a deployed bundle of the bundle bucket.
+type gcBundle struct {
+	Bundle       string    `json:"bundle"`
+	AppID        string    `json:"app_id"`
+	Version      string    `json:"version"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// gcEnvVariables are the environment variables required to collect garbage.
+var gcEnvVariables = []string{
+	"AppsBundleBucketName",
+	"TerraformStateBucket",
+	"AppsAssumeRole",
+	"StaticBucket",
+}
+
+// runGC removes the static assets and manifests referenced by no app version deployed in
+// GCEnvironments, defaulting to every environment with deployment records or deployed bundles,
+// once they are older than StaticRetention, and archives to GCArchiveBucket, or deletes if it is
+// not set, the deployed bundles beyond the last GCKeepBundles of every app. Nothing is removed
+// unless GCApply is true, and the objects which would be are reported.
+// The run lock of every collected environment is held meanwhile, so that no deployment changes the
+// deployment records garbage is told apart with. The garbage collection is skipped if a deployer
+// run is in progress in any of them.
+func runGC(logger appsutils.Logger) {
+	err := initTracing(context.Background())
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to initialize tracing, traces will not be exported")
+	}
+	ctx, span := startSpan(context.Background(), "garbage collection", attribute.String("environment", os.Getenv("Environment")))
+
+	session := setupGC(span, logger)
+
+	report := &gcReport{
+		StartedAt:    time.Now(),
+		DryRun:       os.Getenv("GCApply") != "true",
+		Environments: gcEnvironments(),
+		StaticAssets: []awsTools.ObjectInfo{},
+		Bundles:      []gcBundle{},
+		BundleAction: bundleActionDelete,
+	}
+	if os.Getenv("GCArchiveBucket") != "" {
+		report.BundleAction = bundleActionArchive
+	}
+
+	locked := report.Environments
+	if len(locked) == 0 {
+		locked, err = awsTools.ListDeploymentEnvironments(os.Getenv("TerraformStateBucket"))
+		if err != nil {
+			err = newStageError(categoryStorage, "List environments", errors.Wrap(err, "failed to list the environments with deployment records"))
+			logger.WithError(err).Errorf("Failed to list the environments to collect garbage for")
+			report.Errors = append(report.Errors, err.Error())
+			finishGC(report, exitCodeSetupError, span, logger)
+		}
+	}
+
+	leases, err := acquireGCLeases(ctx, session, locked, logger)
+	if errors.Is(err, lock.ErrLocked) {
+		logger.WithError(err).Infof("A deployer run is in progress, skipping garbage collection")
+		finishGC(report, exitCodeSuccess, span, logger)
+	}
+	if err != nil {
+		err = newStageError(categoryLock, "Acquire run lock", err)
+		logger.WithError(err).Errorf("Failed to acquire the run locks")
+		report.Errors = append(report.Errors, err.Error())
+		finishGC(report, exitCodeSetupError, span, logger)
+	}
+
+	var cancels []context.CancelFunc
+	gcCtx := ctx
+	for _, lease := range leases {
+		var cancel context.CancelFunc
+		gcCtx, cancel = leaseContext(gcCtx, lease, logger)
+		cancels = append(cancels, cancel)
+	}
+	exitCode := collectAndRemoveGarbage(gcCtx, session, report, locked, logger)
+	for _, cancel := range cancels {
+		cancel()
+	}
+	for _, lease := range leases {
+		releaseLease(lease, logger)
+	}
+
+	finishGC(report, exitCode, span, logger)
+}
+
+// setupGC checks the garbage collection configuration, including the notification sinks and the
+// deployment locks, and assumes the deployment role. Unlike setup, it does not require the
+// deployment configuration nor Terraform. It exits with exitCodeSetupError if any check fails.
+func setupGC(span trace.Span, logger appsutils.Logger) *session.Session {
+	report := newRunReport()
+	checkConfiguration(gcEnvVariables, report, span, logger)
+
+	return assumeDeploymentRole(report, span, logger)
+}
+
+// acquireGCLeases claims the run lock of every environment. It returns lock.ErrLocked if a
+// deployer run holds any of them, in which case none is held.
+func acquireGCLeases(ctx context.Context, session *session.Session, environments []string, logger appsutils.Logger) ([]*lock.Lease, error) {
+	var leases []*lock.Lease
+	for _, environment := range environments {
+		lease, err := acquireEnvironmentLease(ctx, session, environment, runLockName)
+		if err != nil {
+			for _, lease := range leases {
+				releaseLease(lease, logger)
+			}
+			return nil, errors.Wrapf(err, "failed to acquire the run lock of %s", environment)
+		}
+		leases = append(leases, lease)
+	}
+
+	return leases, nil
+}
+
+// collectAndRemoveGarbage collects the garbage of the locked environments, and removes it unless
+// the garbage collection is a dry run. It returns the exit code of the garbage collection.
+func collectAndRemoveGarbage(ctx context.Context, session *session.Session, report *gcReport, locked []string, logger appsutils.Logger) int {
+	_, collectSpan := startSpan(ctx, "collect garbage")
+	err := collectGarbage(session, report, locked, logger)
+	endSpan(collectSpan, err)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to collect the stale static assets and bundles")
+		report.Errors = append(report.Errors, err.Error())
+		return exitCodeSetupError
+	}
+
+	logger.Infof("Found %d stale static assets (%d bytes) and %d stale bundles", len(report.StaticAssets), report.StaticBytes, len(report.Bundles))
+	if report.DryRun {
+		logger.Infof("Dry run, set GCApply to true to remove them")
+		return exitCodeSuccess
+	}
+
+	_, removeSpan := startSpan(ctx, "remove garbage")
+	removeGarbage(ctx, session, report, logger)
+	endSpan(removeSpan, nil)
+
+	if len(report.Errors) > 0 {
+		return exitCodeBundlesFailed
+	}
+
+	return exitCodeSuccess
+}
+
+// finishGC writes the report and exits with the given exit code.
+func finishGC(report *gcReport, exitCode int, span trace.Span, logger appsutils.Logger) {
+	report.FinishedAt = time.Now()
+	report.ExitCode = exitCode
+	span.SetAttributes(
+		attribute.Int("static_assets", len(report.StaticAssets)),
+		attribute.Int("bundles", len(report.Bundles)),
+		attribute.Bool("dry_run", report.DryRun),
+	)
+	var err error
+	if len(report.Errors) > 0 {
+		err = errors.New(strings.Join(report.Errors, "; "))
+	}
+	endSpan(span, err)
+	shutdownTracing(logger)
+
+	err = report.write()
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to write garbage collection report")
+	}
+	os.Exit(exitCode)
+}
+
+// collectGarbage finds the stale static assets and bundles. If GCEnvironments is not set, the
+// environments are those with deployment records or deployed bundles. It fails if an environment
+// bundles are deployed in has no deployment records, as the bundles and static assets of its apps
+// would not be protected, or if an environment is not among the locked ones.
+func collectGarbage(session *session.Session, report *gcReport, locked []string, logger appsutils.Logger) error {
+	retention, err := staticRetention()
+	if err != nil {
+		return err
+	}
+	keep, err := gcKeepBundles()
+	if err != nil {
+		return err
+	}
+
+	bundleObjects, err := awsTools.ListObjects(os.Getenv("AppsBundleBucketName"), "", session)
+	if err != nil {
+		return errors.Wrap(err, "failed to list the bundles")
+	}
+	var bundles []gcBundle
+	deployedIn := map[string]bool{}
+	for _, object := range bundleObjects {
+		if !strings.HasSuffix(object.Key, ".zip") {
+			continue
+		}
+
+		tags, err := awsTools.GetObjectTags(os.Getenv("AppsBundleBucketName"), object.Key, session)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get the tags of bundle %s", object.Key)
+		}
+		// Bundles which were never deployed may be waiting for their first deployment.
+		environments := awsTools.DeployedEnvironments(tags)
+		if len(environments) == 0 {
+			continue
+		}
+		for _, environment := range environments {
+			deployedIn[environment] = true
+		}
+		if tags[awsTools.BundleAppIDTag] == "" {
+			logger.Debugf("Bundle %s has no app tags, skipping it", object.Key)
+			report.UntrackedBundles = append(report.UntrackedBundles, object.Key)
+			continue
+		}
+
+		bundles = append(bundles, gcBundle{
+			Bundle:       object.Key,
+			AppID:        tags[awsTools.BundleAppIDTag],
+			Version:      tags[awsTools.BundleAppVersionTag],
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+
+	if len(report.Environments) == 0 {
+		recordEnvironments, err := awsTools.ListDeploymentEnvironments(os.Getenv("TerraformStateBucket"))
+		if err != nil {
+			return errors.Wrap(err, "failed to list the environments with deployment records")
+		}
+		report.Environments = discoverGCEnvironments(recordEnvironments, deployedIn)
+		logger.Infof("Collecting garbage for environments %s", strings.Join(report.Environments, ", "))
+	}
+
+	var records []*model.DeploymentRecord
+	recordsByEnvironment := map[string][]*model.DeploymentRecord{}
+	for _, environment := range report.Environments {
+		environmentRecords, err := awsTools.ListDeploymentRecords(os.Getenv("TerraformStateBucket"), environment)
+		if err != nil {
+			return errors.Wrapf(err, "failed to list the deployment records of %s", environment)
+		}
+		recordsByEnvironment[environment] = environmentRecords
+		records = append(records, environmentRecords...)
+	}
+	err = checkGCEnvironments(deployedIn, recordsByEnvironment)
+	if err != nil {
+		return err
+	}
+	err = checkGCLocks(report.Environments, locked)
+	if err != nil {
+		return err
+	}
+
+	objects, err := awsTools.ListStaticObjects("")
+	if err != nil {
+		return errors.Wrap(err, "failed to list the static assets")
+	}
+	for _, object := range staleStaticObjects(objects, records, retention, time.Now()) {
+		report.StaticAssets = append(report.StaticAssets, object)
+		report.StaticBytes += object.Size
+	}
+
+	report.Bundles = append(report.Bundles, staleBundles(bundles, records, keep)...)
+
+	return nil
+}
+
+// removeGarbage removes the stale static assets, and archives or deletes the stale bundles. It
+// stops if ctx is canceled, once a run lock is lost. Failures are recorded in the report.
+func removeGarbage(ctx context.Context, session *session.Session, report *gcReport, logger appsutils.Logger) {
+	err := checkLease(ctx, "Remove static assets")
+	if err != nil {
+		logger.WithError(err).Errorf("Lost a run lock, leaving the garbage to the next garbage collection")
+		report.Errors = append(report.Errors, err.Error())
+		return
+	}
+
+	var keys []string
+	for _, object := range report.StaticAssets {
+		keys = append(keys, object.Key)
+	}
+	err = awsTools.DeleteStaticFiles(keys, logger)
+	if err != nil {
+		err = newStageError(categoryStorage, "Remove static assets", errors.Wrap(err, "failed to remove the stale static assets"))
+		logger.WithError(err).Errorf("Failed to remove the stale static assets")
+		report.Errors = append(report.Errors, err.Error())
+	}
+
+	for _, bundle := range report.Bundles {
+		err = checkLease(ctx, "Remove bundle")
+		if err != nil {
+			logger.WithError(err).Errorf("Lost a run lock, leaving the remaining bundles to the next garbage collection")
+			report.Errors = append(report.Errors, err.Error())
+			return
+		}
+
+		if report.BundleAction == bundleActionArchive {
+			err = awsTools.ArchiveObject(os.Getenv("AppsBundleBucketName"), bundle.Bundle, os.Getenv("GCArchiveBucket"), session)
+		} else {
+			err = awsTools.DeleteObject(os.Getenv("AppsBundleBucketName"), bundle.Bundle, session)
+		}
+		if err != nil {
+			err = newStageError(categoryStorage, "Remove bundle", errors.Wrapf(err, "failed to %s bundle %s", report.BundleAction, bundle.Bundle))
+			logger.WithError(err).Errorf("Failed to remove stale bundle")
+			report.Errors = append(report.Errors, err.Error())
+			continue
+		}
+		logger.Infof("Removed bundle %s of app %s version %s (%s)", bundle.Bundle, bundle.AppID, bundle.Version, report.BundleAction)
+	}
+}
+
+// staleStaticObjects returns the static assets and manifests referenced by none of the app
+// versions of the deployment records, deployed or retired, which are older than the retention.
+func staleStaticObjects(objects []awsTools.ObjectInfo, records []*model.DeploymentRecord, retention time.Duration, now time.Time) []awsTools.ObjectInfo {
+	var prefixes []string
+	manifests := map[string]bool{}
+	for _, record := range records {
+		versions := []string{record.Version}
+		for _, retired := range record.RetiredVersions {
+			versions = append(versions, retired.Version)
+		}
+		for _, version := range versions {
+			appID, appVersion := appsmodel.AppID(record.AppID), appsmodel.AppVersion(version)
+			prefixes = append(prefixes, apps.S3StaticName(appID, appVersion, ""))
+			manifests[apps.S3ManifestName(appID, appVersion)] = true
+		}
+	}
+
+	var stale []awsTools.ObjectInfo
+	for _, object := range objects {
+		if !strings.HasPrefix(object.Key, staticAssetsPrefix) && !strings.HasPrefix(object.Key, manifestsPrefix) {
+			continue
+		}
+		if manifests[object.Key] || now.Sub(object.LastModified) < retention {
+			continue
+		}
+
+		referenced := false
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(object.Key, prefix) {
+				referenced = true
+				break
+			}
+		}
+		if !referenced {
+			stale = append(stale, object)
+		}
+	}
+
+	return stale
+}
+
+// staleBundles returns the bundles beyond the last kept ones of every app, newest first by app
+// version, or by upload time if the versions are not semantic. The bundles deployed, or which a
+// rollback would redeploy, according to the deployment records are always kept, as well as the
+// bundles of the retired versions whose static assets and lambda functions are still retained.
+func staleBundles(bundles []gcBundle, records []*model.DeploymentRecord, keep int) []gcBundle {
+	protected := map[string]bool{}
+	for _, record := range records {
+		protected[record.Bundle] = true
+		protected[record.PreviousBundle] = true
+		for _, retired := range record.RetiredVersions {
+			protected[retired.Bundle] = true
+		}
+	}
+
+	byApp := map[string][]gcBundle{}
+	var appIDs []string
+	for _, bundle := range bundles {
+		if _, ok := byApp[bundle.AppID]; !ok {
+			appIDs = append(appIDs, bundle.AppID)
+		}
+		byApp[bundle.AppID] = append(byApp[bundle.AppID], bundle)
+	}
+	sort.Strings(appIDs)
+
+	var stale []gcBundle
+	for _, appID := range appIDs {
+		appBundles := byApp[appID]
+		sort.SliceStable(appBundles, func(i, j int) bool {
+			return newerBundle(appBundles[i], appBundles[j])
+		})
+
+		for i, bundle := range appBundles {
+			if i >= keep && !protected[bundle.Bundle] {
+				stale = append(stale, bundle)
+			}
+		}
+	}
+
+	return stale
+}
+
+// newerBundle returns true if the first bundle is a newer app version than the second one.
+func newerBundle(first, second gcBundle) bool {
+	firstVersion, firstErr := version.NewVersion(first.Version)
+	secondVersion, secondErr := version.NewVersion(second.Version)
+	if firstErr == nil && secondErr == nil && !firstVersion.Equal(secondVersion) {
+		return firstVersion.GreaterThan(secondVersion)
+	}
+
+	return first.LastModified.After(second.LastModified)
+}
+
+// gcEnvironments returns the comma separated GCEnvironments, or nil if it is not set and the
+// environments are discovered.
+func gcEnvironments() []string {
+	var environments []string
+	for _, environment := range strings.Split(os.Getenv("GCEnvironments"), ",") {
+		if environment = strings.TrimSpace(environment); environment != "" {
+			environments = append(environments, environment)
+		}
+	}
+
+	return environments
+}
+
+// discoverGCEnvironments returns the sorted environments with deployment records or deployed
+// bundles.
+func discoverGCEnvironments(recordEnvironments []string, deployedIn map[string]bool) []string {
+	found := map[string]bool{}
+	var environments []string
+	for _, environment := range recordEnvironments {
+		if !found[environment] {
+			found[environment] = true
+			environments = append(environments, environment)
+		}
+	}
+	for environment := range deployedIn {
+		if !found[environment] {
+			found[environment] = true
+			environments = append(environments, environment)
+		}
+	}
+	sort.Strings(environments)
+
+	return environments
+}
+
+// checkGCEnvironments returns an error if bundles are deployed in an environment without
+// deployment records, either as it is not collected or as its records are missing, since the
+// static assets and bundles of its apps cannot be told apart from garbage.
+func checkGCEnvironments(deployedIn map[string]bool, records map[string][]*model.DeploymentRecord) error {
+	var missing []string
+	for environment := range deployedIn {
+		if len(records[environment]) == 0 {
+			missing = append(missing, environment)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("bundles are deployed in environments %s without deployment records, refusing to collect garbage", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// checkGCLocks returns an error if garbage is collected in an environment whose run lock is not
+// held, which happens if its first deployment record was stored after the locks were acquired.
+func checkGCLocks(environments, locked []string) error {
+	held := map[string]bool{}
+	for _, environment := range locked {
+		held[environment] = true
+	}
+
+	var unlocked []string
+	for _, environment := range environments {
+		if !held[environment] {
+			unlocked = append(unlocked, environment)
+		}
+	}
+	if len(unlocked) > 0 {
+		return errors.Errorf("the run locks of environments %s are not held, refusing to collect garbage", strings.Join(unlocked, ", "))
+	}
+
+	return nil
+}
+
+func gcKeepBundles() (int, error) {
+	if os.Getenv("GCKeepBundles") == "" {
+		return defaultGCKeepBundles, nil
+	}
+
+	keep, err := strconv.Atoi(os.Getenv("GCKeepBundles"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse GCKeepBundles")
+	}
+	if keep < 1 {
+		return 0, errors.Errorf("GCKeepBundles must be at least 1, got %d", keep)
+	}
+
+	return keep, nil
+}
+
+// write writes the report to RunReportPath. It is a no-op if RunReportPath is not set.
+func (r *gcReport) write() error {
+	reportPath := os.Getenv("RunReportPath")
+	if reportPath == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal garbage collection report")
+	}
+
+	err = os.WriteFile(reportPath, content, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write garbage collection report")
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	"github.com/mattermost/mattermost-apps/internal/tools/lock"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestStaleStaticObjects(t *testing.T) {
+	now := time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-8 * 24 * time.Hour)
+	records := []*model.DeploymentRecord{
+		{AppID: "hello-world", Version: "1.2.0", RetiredVersions: []model.RetiredVersion{{Version: "1.1.0"}}},
+		{AppID: "hello-world", Version: "1.0.0"},
+	}
+	staticKey := func(version, name string) string {
+		return apps.S3StaticName("hello-world", appsmodel.AppVersion(version), name)
+	}
+	manifestKey := func(version string) string {
+		return apps.S3ManifestName("hello-world", appsmodel.AppVersion(version))
+	}
+
+	objects := []awsTools.ObjectInfo{
+		{Key: staticKey("1.2.0", "icon.png"), LastModified: old},
+		{Key: manifestKey("1.2.0"), LastModified: old},
+		{Key: staticKey("1.1.0", "icon.png"), LastModified: old},
+		{Key: staticKey("1.0.0", "icon.png"), LastModified: old},
+		{Key: staticKey("0.9.0", "icon.png"), LastModified: old},
+		{Key: manifestKey("0.9.0"), LastModified: old},
+		{Key: staticKey("0.8.0", "icon.png"), LastModified: now.Add(-time.Hour)},
+		{Key: "other/file.txt", LastModified: old},
+	}
+
+	stale := staleStaticObjects(objects, records, defaultStaticRetention, now)
+	require.Len(t, stale, 2)
+	assert.Equal(t, staticKey("0.9.0", "icon.png"), stale[0].Key)
+	assert.Equal(t, manifestKey("0.9.0"), stale[1].Key)
+}
+
+func TestStaleBundles(t *testing.T) {
+	now := time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC)
+	bundle := func(appID, version string, age time.Duration) gcBundle {
+		return gcBundle{Bundle: appID + "_" + version + ".zip", AppID: appID, Version: version, LastModified: now.Add(-age)}
+	}
+	bundles := []gcBundle{
+		bundle("hello-world", "1.0.0", 5*time.Hour),
+		bundle("hello-world", "1.10.0", 4*time.Hour),
+		bundle("hello-world", "1.2.0", 3*time.Hour),
+		bundle("hello-world", "1.9.0", 2*time.Hour),
+		bundle("other", "stable", 3*time.Hour),
+		bundle("other", "latest", 2*time.Hour),
+		bundle("other", "nightly", time.Hour),
+	}
+
+	t.Run("keep newest versions", func(t *testing.T) {
+		stale := staleBundles(bundles, nil, 2)
+		require.Len(t, stale, 3)
+		assert.Equal(t, "hello-world_1.2.0.zip", stale[0].Bundle)
+		assert.Equal(t, "hello-world_1.0.0.zip", stale[1].Bundle)
+		assert.Equal(t, "other_stable.zip", stale[2].Bundle)
+	})
+
+	t.Run("keep deployed bundles", func(t *testing.T) {
+		records := []*model.DeploymentRecord{
+			{AppID: "hello-world", Bundle: "hello-world_1.0.0.zip", PreviousBundle: "hello-world_1.2.0.zip"},
+		}
+		stale := staleBundles(bundles, records, 2)
+		require.Len(t, stale, 1)
+		assert.Equal(t, "other_stable.zip", stale[0].Bundle)
+	})
+
+	t.Run("keep retired bundles", func(t *testing.T) {
+		records := []*model.DeploymentRecord{{
+			AppID:           "hello-world",
+			Bundle:          "hello-world_1.10.0.zip",
+			PreviousBundle:  "hello-world_1.9.0.zip",
+			RetiredVersions: []model.RetiredVersion{{Version: "1.2.0", Bundle: "hello-world_1.2.0.zip"}},
+		}}
+		stale := staleBundles(bundles, records, 2)
+		require.Len(t, stale, 2)
+		assert.Equal(t, "hello-world_1.0.0.zip", stale[0].Bundle)
+		assert.Equal(t, "other_stable.zip", stale[1].Bundle)
+	})
+
+	t.Run("keep all", func(t *testing.T) {
+		assert.Empty(t, staleBundles(bundles, nil, defaultGCKeepBundles))
+	})
+}
+
+func TestAcquireGCLeases(t *testing.T) {
+	t.Setenv("LockDir", t.TempDir())
+	logger := appsutils.NewTestLogger()
+
+	runLease, err := acquireEnvironmentLease(context.Background(), nil, "production", runLockName)
+	require.NoError(t, err)
+
+	_, err = acquireGCLeases(context.Background(), nil, []string{"staging", "production"}, logger)
+	assert.ErrorIs(t, err, lock.ErrLocked)
+
+	// The leases acquired before the locked one are released.
+	require.NoError(t, runLease.Release(context.Background()))
+	leases, err := acquireGCLeases(context.Background(), nil, []string{"staging", "production"}, logger)
+	require.NoError(t, err)
+	require.Len(t, leases, 2)
+	assert.Equal(t, "staging/run", leases[0].Name())
+	assert.Equal(t, "production/run", leases[1].Name())
+
+	_, err = acquireEnvironmentLease(context.Background(), nil, "staging", runLockName)
+	assert.ErrorIs(t, err, lock.ErrLocked, "deployer runs wait for the garbage collection")
+	for _, lease := range leases {
+		releaseLease(lease, logger)
+	}
+}
+
+func TestGCKeepBundles(t *testing.T) {
+	t.Setenv("GCKeepBundles", "")
+	keep, err := gcKeepBundles()
+	require.NoError(t, err)
+	assert.Equal(t, defaultGCKeepBundles, keep)
+
+	t.Setenv("GCKeepBundles", "2")
+	keep, err = gcKeepBundles()
+	require.NoError(t, err)
+	assert.Equal(t, 2, keep)
+
+	t.Setenv("GCKeepBundles", "0")
+	_, err = gcKeepBundles()
+	assert.Error(t, err)
+}
+
+func TestGCEnvironments(t *testing.T) {
+	t.Setenv("Environment", "staging")
+	t.Setenv("GCEnvironments", "")
+
+	assert.Empty(t, gcEnvironments(), "environments are discovered if not set")
+
+	t.Setenv("GCEnvironments", "staging, production,")
+	assert.Equal(t, []string{"staging", "production"}, gcEnvironments())
+
+	t.Run("discover", func(t *testing.T) {
+		environments := discoverGCEnvironments([]string{"staging", "production"}, map[string]bool{"production": true, "qa": true})
+		assert.Equal(t, []string{"production", "qa", "staging"}, environments)
+	})
+
+	t.Run("check", func(t *testing.T) {
+		records := map[string][]*model.DeploymentRecord{
+			"staging": {{AppID: "hello-world", Bundle: "hello-world_1.0.0.zip"}},
+			"qa":      {},
+		}
+		assert.NoError(t, checkGCEnvironments(map[string]bool{"staging": true}, records))
+
+		err := checkGCEnvironments(map[string]bool{"staging": true, "qa": true, "production": true}, records)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "production, qa")
+	})
+
+	t.Run("check locks", func(t *testing.T) {
+		assert.NoError(t, checkGCLocks([]string{"staging"}, []string{"production", "staging"}))
+
+		err := checkGCLocks([]string{"qa", "staging"}, []string{"staging"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "qa")
+	})
+}
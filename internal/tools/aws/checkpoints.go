@@ -0,0 +1,95 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+// checkpointKey returns the S3 key of the deployment checkpoint of a bundle in an environment.
+func checkpointKey(environment, bundle string) string {
+	return fmt.Sprintf("checkpoints/%s/%s.json", environment, strings.TrimSuffix(bundle, ".zip"))
+}
+
+// GetCheckpoint returns the deployment checkpoint of a bundle in an environment from the given
+// bucket, or nil if no deployment of the bundle failed before.
+func GetCheckpoint(bucketName, environment, bundle string) (*model.Checkpoint, error) {
+	svc := s3.New(newSession())
+	result, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(checkpointKey(environment, bundle)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	var checkpoint model.Checkpoint
+	err = json.NewDecoder(result.Body).Decode(&checkpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode checkpoint")
+	}
+
+	return &checkpoint, nil
+}
+
+// PutCheckpoint stores the deployment checkpoint of a bundle in the given bucket.
+func PutCheckpoint(bucketName string, checkpoint *model.Checkpoint) error {
+	body, err := json.Marshal(checkpoint)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+
+	svc := s3.New(newSession())
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(checkpointKey(checkpoint.Environment, checkpoint.Bundle)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCheckpoint removes the deployment checkpoint of a bundle in an environment.
+func DeleteCheckpoint(bucketName, environment, bundle string) error {
+	svc := s3.New(newSession())
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(checkpointKey(environment, bundle)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetObjectETag returns the ETag of an object, which changes when the object is uploaded again.
+func GetObjectETag(bucketName, objectKey string, session *session.Session) (string, error) {
+	svc := s3.New(session)
+	result, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(result.ETag), nil
+}
@@ -0,0 +1,68 @@
+package aws
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// S3Object is an object referenced by an S3 event notification.
+type S3Object struct {
+	Bucket string
+	Key    string
+}
+
+// s3Event is an S3 event notification, as delivered to SQS.
+type s3Event struct {
+	Records []struct {
+		EventName string `json:"eventName"`
+		S3        struct {
+			Bucket struct {
+				Name string `json:"name"`
+			} `json:"bucket"`
+			Object struct {
+				Key string `json:"key"`
+			} `json:"object"`
+		} `json:"s3"`
+	} `json:"Records"`
+}
+
+// snsEnvelope is an SNS notification delivered to SQS without raw message delivery.
+type snsEnvelope struct {
+	Type    string `json:"Type"`
+	Message string `json:"Message"`
+}
+
+// ParseS3CreatedEvent returns the objects created according to the S3 event notification. Test
+// events and other event types carry no created objects. Notifications fanned out through SNS
+// are unwrapped.
+func ParseS3CreatedEvent(body []byte) ([]S3Object, error) {
+	var envelope snsEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Type == "Notification" {
+		body = []byte(envelope.Message)
+	}
+
+	var event s3Event
+	err := json.Unmarshal(body, &event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse S3 event")
+	}
+
+	var objects []S3Object
+	for _, record := range event.Records {
+		if !strings.HasPrefix(record.EventName, "ObjectCreated:") {
+			continue
+		}
+
+		// Object keys are URL encoded in event notifications.
+		key, err := url.QueryUnescape(record.S3.Object.Key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid object key %s", record.S3.Object.Key)
+		}
+		objects = append(objects, S3Object{Bucket: record.S3.Bucket.Name, Key: key})
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,50 @@
+package aws
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseS3CreatedEvent(t *testing.T) {
+	event := `{
+  "Records": [
+    {
+      "eventName": "ObjectCreated:Put",
+      "s3": {"bucket": {"name": "apps-bundles"}, "object": {"key": "hello+world_v1.0.0.zip"}}
+    },
+    {
+      "eventName": "ObjectRemoved:Delete",
+      "s3": {"bucket": {"name": "apps-bundles"}, "object": {"key": "old.zip"}}
+    }
+  ]
+}`
+
+	t.Run("sqs", func(t *testing.T) {
+		objects, err := ParseS3CreatedEvent([]byte(event))
+		require.NoError(t, err)
+		assert.Equal(t, []S3Object{{Bucket: "apps-bundles", Key: "hello world_v1.0.0.zip"}}, objects)
+	})
+
+	t.Run("sns", func(t *testing.T) {
+		body, err := json.Marshal(snsEnvelope{Type: "Notification", Message: event})
+		require.NoError(t, err)
+
+		objects, err := ParseS3CreatedEvent(body)
+		require.NoError(t, err)
+		assert.Equal(t, []S3Object{{Bucket: "apps-bundles", Key: "hello world_v1.0.0.zip"}}, objects)
+	})
+
+	t.Run("test event", func(t *testing.T) {
+		objects, err := ParseS3CreatedEvent([]byte(`{"Service":"Amazon S3","Event":"s3:TestEvent","Bucket":"apps-bundles"}`))
+		require.NoError(t, err)
+		assert.Empty(t, objects)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ParseS3CreatedEvent([]byte("not json"))
+		assert.Error(t, err)
+	})
+}
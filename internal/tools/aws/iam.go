@@ -11,7 +11,7 @@ import (
 
 // GetAssumeRoleSession assumes an IAM role and returns the session.
 func GetAssumeRoleSession(iamRole string) (*session.Session, error) {
-	s, err := session.NewSession()
+	s, err := session.NewSession(endpointConfig())
 	if err != nil {
 		return nil, err
 	}
@@ -29,9 +29,7 @@ func GetAssumeRoleSession(iamRole string) (*session.Session, error) {
 	}
 
 	provider := NewAssumeRoleCredentialsProvider(assumeRole.Credentials)
-	session, err := session.NewSession(&aws.Config{
-		Credentials: credentials.NewCredentials(provider),
-	})
+	session, err := session.NewSession(endpointConfig().WithCredentials(credentials.NewCredentials(provider)))
 	if err != nil {
 		return nil, err
 	}
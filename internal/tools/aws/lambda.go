@@ -0,0 +1,100 @@
+package aws
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// GetLambdaAliasVersion returns the function version the lambda alias currently points at.
+func GetLambdaAliasVersion(functionName, alias string) (string, error) {
+	svc := lambda.New(newSession())
+	result, err := svc.GetAlias(&lambda.GetAliasInput{
+		FunctionName: aws.String(functionName),
+		Name:         aws.String(alias),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.StringValue(result.FunctionVersion), nil
+}
+
+// UpdateLambdaAlias points the lambda alias at the given version. When a canary version is
+// provided, the given weight of the alias traffic is routed to the canary version instead.
+func UpdateLambdaAlias(functionName, alias, version, canaryVersion string, canaryWeight float64) error {
+	routingConfig := &lambda.AliasRoutingConfiguration{
+		AdditionalVersionWeights: map[string]*float64{},
+	}
+	if canaryVersion != "" {
+		routingConfig.AdditionalVersionWeights[canaryVersion] = aws.Float64(canaryWeight)
+	}
+
+	svc := lambda.New(newSession())
+	_, err := svc.UpdateAlias(&lambda.UpdateAliasInput{
+		FunctionName:    aws.String(functionName),
+		Name:            aws.String(alias),
+		FunctionVersion: aws.String(version),
+		RoutingConfig:   routingConfig,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetLambdaVersionErrors returns the number of errors reported for the given function version
+// invoked through the alias since the given time.
+func GetLambdaVersionErrors(functionName, alias, version string, since time.Time) (float64, error) {
+	svc := cloudwatch.New(newSession())
+
+	now := time.Now()
+	period := int64(now.Sub(since).Seconds())
+	// CloudWatch periods must be a multiple of 60 seconds.
+	period = (period/60 + 1) * 60
+
+	result, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/Lambda"),
+		MetricName: aws.String("Errors"),
+		Dimensions: []*cloudwatch.Dimension{
+			{Name: aws.String("FunctionName"), Value: aws.String(functionName)},
+			{Name: aws.String("Resource"), Value: aws.String(fmt.Sprintf("%s:%s", functionName, alias))},
+			{Name: aws.String("ExecutedVersion"), Value: aws.String(version)},
+		},
+		StartTime:  aws.Time(since),
+		EndTime:    aws.Time(now),
+		Period:     aws.Int64(period),
+		Statistics: []*string{aws.String(cloudwatch.StatisticSum)},
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var errors float64
+	for _, datapoint := range result.Datapoints {
+		errors += aws.Float64Value(datapoint.Sum)
+	}
+
+	return errors, nil
+}
+
+// InvokeLambda synchronously invokes the given lambda function version and returns the response
+// payload and the function error reported by Lambda, if any.
+func InvokeLambda(functionName, qualifier string, payload []byte) ([]byte, string, error) {
+	svc := lambda.New(newSession())
+	result, err := svc.Invoke(&lambda.InvokeInput{
+		FunctionName:   aws.String(functionName),
+		Qualifier:      aws.String(qualifier),
+		InvocationType: aws.String(lambda.InvocationTypeRequestResponse),
+		Payload:        payload,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return result.Payload, aws.StringValue(result.FunctionError), nil
+}
@@ -0,0 +1,113 @@
+package aws
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// BundleAppIDTag is the tag of the app ID of deployed bundles.
+	BundleAppIDTag = "app_id"
+	// BundleAppVersionTag is the tag of the app version of deployed bundles.
+	BundleAppVersionTag = "app_version"
+	// deployedTagPrefix prefixes the tags marking the environments a bundle is deployed in.
+	deployedTagPrefix = "deployed_"
+)
+
+// ObjectInfo covers an object of a bucket.
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ListObjects returns the objects of the bucket with the given prefix.
+func ListObjects(bucketName, prefix string, session *session.Session) ([]ObjectInfo, error) {
+	svc := s3.New(session)
+
+	var objects []ObjectInfo
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+// GetObjectTags returns the tags of an object.
+func GetObjectTags(bucketName, objectKey string, session *session.Session) (map[string]string, error) {
+	svc := s3.New(session)
+	result, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(result.TagSet))
+	for _, tag := range result.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+
+	return tags, nil
+}
+
+// DeployedEnvironments returns the environments a bundle is deployed in according to its tags.
+func DeployedEnvironments(tags map[string]string) []string {
+	var environments []string
+	for key, value := range tags {
+		if strings.HasPrefix(key, deployedTagPrefix) && value == "true" {
+			environments = append(environments, strings.TrimPrefix(key, deployedTagPrefix))
+		}
+	}
+
+	return environments
+}
+
+// ArchiveObject copies an object, with its tags, to the archive bucket, then deletes it.
+func ArchiveObject(bucketName, objectKey, archiveBucketName string, session *session.Session) error {
+	svc := s3.New(session)
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:           aws.String(archiveBucketName),
+		Key:              aws.String(objectKey),
+		CopySource:       aws.String(bucketName + "/" + url.PathEscape(objectKey)),
+		TaggingDirective: aws.String(s3.TaggingDirectiveCopy),
+	})
+	if err != nil {
+		return err
+	}
+
+	return DeleteObject(bucketName, objectKey, session)
+}
+
+// DeleteObject deletes an object.
+func DeleteObject(bucketName, objectKey string, session *session.Session) error {
+	svc := s3.New(session)
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
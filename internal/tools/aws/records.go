@@ -0,0 +1,136 @@
+package aws
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+// deploymentRecordKey returns the S3 key of the deployment record of an app in an environment.
+func deploymentRecordKey(environment, appID string) string {
+	return fmt.Sprintf("deployments/%s/%s.json", environment, appID)
+}
+
+// GetDeploymentRecord returns the deployment record of an app in an environment from the given
+// bucket, or nil if the app was not deployed before.
+func GetDeploymentRecord(bucketName, environment, appID string) (*model.DeploymentRecord, error) {
+	svc := s3.New(newSession())
+	result, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(deploymentRecordKey(environment, appID)),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	var record model.DeploymentRecord
+	err = json.NewDecoder(result.Body).Decode(&record)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode deployment record")
+	}
+
+	return &record, nil
+}
+
+// PutDeploymentRecord stores the deployment record of an app in the given bucket.
+func PutDeploymentRecord(bucketName string, record *model.DeploymentRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode deployment record")
+	}
+
+	svc := s3.New(newSession())
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucketName),
+		Key:         aws.String(deploymentRecordKey(record.Environment, record.AppID)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ListDeploymentRecords returns the deployment records of every app deployed in an environment.
+func ListDeploymentRecords(bucketName, environment string) ([]*model.DeploymentRecord, error) {
+	svc := s3.New(newSession())
+
+	var records []*model.DeploymentRecord
+	var listErr error
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucketName),
+		Prefix: aws.String(fmt.Sprintf("deployments/%s/", environment)),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			appID := strings.TrimSuffix(path.Base(aws.StringValue(object.Key)), ".json")
+			record, err := GetDeploymentRecord(bucketName, environment, appID)
+			if err != nil {
+				listErr = errors.Wrapf(err, "failed to get deployment record of app %s", appID)
+				return false
+			}
+			if record != nil {
+				records = append(records, record)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if listErr != nil {
+		return nil, listErr
+	}
+
+	return records, nil
+}
+
+// ListDeploymentEnvironments returns the environments with deployment records.
+func ListDeploymentEnvironments(bucketName string) ([]string, error) {
+	svc := s3.New(newSession())
+
+	var environments []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucketName),
+		Prefix:    aws.String("deployments/"),
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, prefix := range page.CommonPrefixes {
+			environments = append(environments, path.Base(aws.StringValue(prefix.Prefix)))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return environments, nil
+}
+
+// DeleteDeploymentRecord removes the deployment record of an app in an environment.
+func DeleteDeploymentRecord(bucketName, environment, appID string) error {
+	svc := s3.New(newSession())
+	_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucketName),
+		Key:    aws.String(deploymentRecordKey(environment, appID)),
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
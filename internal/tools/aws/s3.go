@@ -2,15 +2,20 @@ package aws
 
 import (
 	"fmt"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
 
+	model "github.com/mattermost/mattermost-apps/model"
 	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
 	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
 )
@@ -38,64 +43,139 @@ func DownloadS3Object(bucketName, object, dir string, session *session.Session)
 	return nil
 }
 
-// UploadStaticFiles is used to upload static files to the static S3 bucket.
-func UploadStaticFiles(staticFiles map[string]apps.AssetData, bundleName string, logger appsutils.Logger) error {
+// UploadStaticFiles is used to upload static files to the static S3 bucket, cached as immutable.
+// The keys of the static files are scoped by app ID and version, so that the assets of the live
+// app version are left untouched until the manifest of the new version is published.
+//
+// Files identical to the objects already in the bucket are skipped, and the others are uploaded
+// by StaticUploadConcurrency workers, defaulting to defaultUploadConcurrency.
+func UploadStaticFiles(staticFiles map[string]apps.AssetData, bundleName string, logger appsutils.Logger) (*model.UploadResult, error) {
+	concurrency, err := uploadConcurrency()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &model.UploadResult{}
+	svc := s3.New(newSession())
+	uploader := s3manager.NewUploaderWithClient(svc)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var uploadErr error
+	workers := make(chan struct{}, concurrency)
 	for staticFile, staticKey := range staticFiles {
-		bundleDir := path.Join(os.Getenv("TempDir"), bundleName)
-		fileDir := path.Join(bundleDir, "static", staticFile)
-		file, err := os.Open(fileDir)
-		if err != nil {
-			return err
+		lock.Lock()
+		failed := uploadErr != nil
+		lock.Unlock()
+		if failed {
+			break
 		}
 
-		defer file.Close()
+		workers <- struct{}{}
+		wg.Add(1)
+		go func(filePath, key string) {
+			defer func() {
+				<-workers
+				wg.Done()
+			}()
 
-		uploader := s3manager.NewUploader(session.New())
-		_, err = uploader.Upload(
-			&s3manager.UploadInput{
-				Bucket: aws.String(os.Getenv("StaticBucket")),
-				Key:    aws.String(staticKey.Key),
-				Body:   file,
-			})
-		if err != nil {
-			return err
+			uploaded, size, err := uploadStaticFile(svc, uploader, filePath, key, logger)
+
+			lock.Lock()
+			defer lock.Unlock()
+			switch {
+			case err != nil:
+				if uploadErr == nil {
+					uploadErr = errors.Wrapf(err, "failed to upload %s", key)
+				}
+			case uploaded:
+				result.UploadedFiles++
+				result.UploadedBytes += size
+			default:
+				result.SkippedFiles++
+				result.SkippedBytes += size
+			}
+		}(path.Join(os.Getenv("TempDir"), bundleName, "static", staticFile), staticKey.Key)
+	}
+	wg.Wait()
+
+	if uploadErr != nil {
+		return result, uploadErr
+	}
+
+	logger.Infof("Uploaded %d static files (%d bytes), skipped %d unchanged static files (%d bytes)", result.UploadedFiles, result.UploadedBytes, result.SkippedFiles, result.SkippedBytes)
+	return result, nil
+}
+
+// uploadStaticFile uploads the file to the static S3 bucket, unless the object already holds its
+// content with the configured settings. It returns whether the file was uploaded, and its size.
+func uploadStaticFile(svc *s3.S3, uploader *s3manager.Uploader, filePath, key string, logger appsutils.Logger) (bool, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return false, 0, err
+	}
+	defer file.Close()
+
+	input, size, err := newUploadInput(key, file, staticCacheControl(), true)
+	if err != nil {
+		return false, 0, err
+	}
+
+	existing, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: input.Bucket,
+		Key:    input.Key,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); !ok || aerr.StatusCode() != http.StatusNotFound {
+			return false, 0, errors.Wrap(err, "failed to get the existing object")
 		}
+	} else if isUnchanged(input, existing) {
+		logger.Debugf("Skipped unchanged file %s with object name %s", file.Name(), key)
+		return false, size, nil
+	}
 
-		logger.Infof("Uploaded file %s with object name %s", file.Name(), staticKey.Key)
+	_, err = uploader.Upload(input)
+	if err != nil {
+		return false, 0, err
 	}
-	return nil
+
+	logger.Infof("Uploaded file %s with object name %s", file.Name(), key)
+	return true, size, nil
 }
 
-// UploadManifestFile is used to upload the manifest file to the static S3 bucket.
-func UploadManifestFile(manifestKey, manifestFileName, bundleName string, logger appsutils.Logger) error {
+// UploadManifestFile is used to upload the manifest file to the static S3 bucket, with a short
+// cache policy and without compression, as the Apps plugin does not decode it. It returns the
+// number of bytes uploaded.
+func UploadManifestFile(manifestKey, manifestFileName, bundleName string, logger appsutils.Logger) (int64, error) {
 	bundleDir := path.Join(os.Getenv("TempDir"), bundleName)
 	fileDir := path.Join(bundleDir, manifestFileName)
 	file, err := os.Open(fileDir)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	defer file.Close()
 
-	uploader := s3manager.NewUploader(session.New())
-	_, err = uploader.Upload(
-		&s3manager.UploadInput{
-			Bucket: aws.String(os.Getenv("StaticBucket")),
-			Key:    aws.String(manifestKey),
-			Body:   file,
-		})
+	input, size, err := newUploadInput(manifestKey, file, manifestCacheControl(), false)
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	uploader := s3manager.NewUploader(newSession())
+	_, err = uploader.Upload(input)
+	if err != nil {
+		return 0, err
 	}
 
 	logger.Infof("Uploaded file %s with object name %s", file.Name(), manifestKey)
-	return nil
+	return size, nil
 }
 
-// GetBundles is used to get all app bundles from a S3 bucket.
+// GetBundles is used to get all app bundles from a S3 bucket. It returns the bundles which are
+// not deployed yet, followed by the ones which are already deployed.
 // TODO: Limit of 1000 objects per API call should be handled.
-func GetBundles(bucketName string, session *session.Session, logger appsutils.Logger) ([]string, error) {
-	var bundles []string
+func GetBundles(bucketName string, session *session.Session, logger appsutils.Logger) ([]string, []string, error) {
+	var bundles, deployed []string
 
 	svc := s3.New(session)
 	input := &s3.ListObjectsV2Input{
@@ -104,23 +184,24 @@ func GetBundles(bucketName string, session *session.Session, logger appsutils.Lo
 
 	result, err := svc.ListObjectsV2(input)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	for _, content := range result.Contents {
 		if strings.HasSuffix(*content.Key, ".zip") {
 			isDeployed, err := IsBundleDeployed(bucketName, *content.Key, session)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 			if !isDeployed {
 				bundles = append(bundles, *content.Key)
 			} else {
 				logger.Infof("Bundle %s is already deployed", *content.Key)
+				deployed = append(deployed, *content.Key)
 			}
 		}
 	}
-	return bundles, nil
+	return bundles, deployed, nil
 }
 
 // IsBundleDeployed checks the bundle object tag to check if it got deployed before.
@@ -143,8 +224,9 @@ func IsBundleDeployed(bucketName, objectKey string, session *session.Session) (b
 	return false, nil
 }
 
-// PutDeployedObjectTag adds a tag to specify that the bundle was deployed.
-func PutDeployedObjectTag(bucketName, objectKey string, session *session.Session) error {
+// PutDeployedObjectTag adds a tag to specify that the bundle was deployed, along with the tags of
+// the app ID and version of the bundle.
+func PutDeployedObjectTag(bucketName, objectKey, appID, version string, session *session.Session) error {
 	svc := s3.New(session)
 
 	result, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
@@ -155,28 +237,23 @@ func PutDeployedObjectTag(bucketName, objectKey string, session *session.Session
 		return err
 	}
 
-	tagKey := fmt.Sprintf("deployed_%s", os.Getenv("Environment"))
-	tagExists := false
-	var tags []*s3.Tag
+	newTags := []*s3.Tag{
+		{Key: aws.String(fmt.Sprintf("deployed_%s", os.Getenv("Environment"))), Value: aws.String("true")},
+		{Key: aws.String(BundleAppIDTag), Value: aws.String(appID)},
+		{Key: aws.String(BundleAppVersionTag), Value: aws.String(version)},
+	}
 
+	var tags []*s3.Tag
 	for _, tag := range result.TagSet {
-		if *tag.Key == tagKey {
-			tags = append(tags, &s3.Tag{
-				Key:   aws.String(tagKey),
-				Value: aws.String("true"),
-			})
-			tagExists = true
-		} else {
+		replaced := false
+		for _, newTag := range newTags {
+			replaced = replaced || *tag.Key == *newTag.Key
+		}
+		if !replaced {
 			tags = append(tags, tag)
 		}
 	}
-
-	if !tagExists {
-		tags = append(tags, &s3.Tag{
-			Key:   aws.String(tagKey),
-			Value: aws.String("true"),
-		})
-	}
+	tags = append(tags, newTags...)
 
 	input := &s3.PutObjectTaggingInput{
 		Bucket: aws.String(bucketName),
@@ -192,3 +269,63 @@ func PutDeployedObjectTag(bucketName, objectKey string, session *session.Session
 	}
 	return nil
 }
+
+// StaticObjectExists checks if an object exists in the static S3 bucket.
+func StaticObjectExists(key string) (bool, error) {
+	svc := s3.New(newSession())
+	_, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(os.Getenv("StaticBucket")),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.RequestFailure); ok && aerr.StatusCode() == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// DeleteStaticFiles removes objects from the static S3 bucket.
+func DeleteStaticFiles(keys []string, logger appsutils.Logger) error {
+	svc := s3.New(newSession())
+	for _, key := range keys {
+		_, err := svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(os.Getenv("StaticBucket")),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		logger.Infof("Removed object %s", key)
+	}
+
+	return nil
+}
+
+// ListStaticFiles returns the keys of the objects of the static S3 bucket with the given prefix.
+func ListStaticFiles(prefix string) ([]string, error) {
+	svc := s3.New(newSession())
+
+	var keys []string
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(os.Getenv("StaticBucket")),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			keys = append(keys, aws.StringValue(object.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ListStaticObjects returns the objects of the static S3 bucket with the given prefix.
+func ListStaticObjects(prefix string) ([]ObjectInfo, error) {
+	return ListObjects(os.Getenv("StaticBucket"), prefix, newSession())
+}
@@ -0,0 +1,25 @@
+package aws
+
+import (
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// endpointConfig returns the configuration of the deployer sessions. If AWSEndpoint is set, every
+// service is called at that endpoint, with path-style S3 addressing, for instance to run the
+// deployer against LocalStack.
+func endpointConfig() *aws.Config {
+	config := aws.NewConfig()
+	if os.Getenv("AWSEndpoint") != "" {
+		config = config.WithEndpoint(os.Getenv("AWSEndpoint")).WithS3ForcePathStyle(true)
+	}
+
+	return config
+}
+
+// newSession returns a session of the deployer account.
+func newSession() *session.Session {
+	return session.New(endpointConfig())
+}
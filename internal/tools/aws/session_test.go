@@ -0,0 +1,20 @@
+package aws
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointConfig(t *testing.T) {
+	t.Setenv("AWSEndpoint", "")
+	config := endpointConfig()
+	assert.Nil(t, config.Endpoint)
+	assert.Nil(t, config.S3ForcePathStyle)
+
+	t.Setenv("AWSEndpoint", "http://localhost:4566")
+	config = endpointConfig()
+	assert.Equal(t, "http://localhost:4566", aws.StringValue(config.Endpoint))
+	assert.True(t, aws.BoolValue(config.S3ForcePathStyle))
+}
@@ -0,0 +1,247 @@
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultStaticCacheControl is the cache policy of the static assets if StaticCacheControl is
+	// not set. Static asset keys are scoped by app version, so they are cached as immutable.
+	defaultStaticCacheControl = "public, max-age=31536000, immutable"
+	// defaultManifestCacheControl is the cache policy of the manifests if ManifestCacheControl is
+	// not set, short so that the Apps plugin sees a new version quickly.
+	defaultManifestCacheControl = "public, max-age=60"
+)
+
+const (
+	// encodingGzip pre-compresses the uploaded files with gzip.
+	encodingGzip = "gzip"
+	// encodingBrotli pre-compresses the uploaded files with brotli.
+	encodingBrotli = "br"
+)
+
+// defaultUploadConcurrency is the number of static files uploaded at once if
+// StaticUploadConcurrency is not set.
+const defaultUploadConcurrency = 8
+
+const (
+	// checksumMetadata is the metadata key of the SHA-256 checksum of uploaded objects, as
+	// returned by S3.
+	checksumMetadata = "Sha256"
+	// aclMetadata is the metadata key of the canned ACL uploaded objects were granted, which
+	// HeadObject does not return.
+	aclMetadata = "Acl"
+	// kmsKeyMetadata is the metadata key of the configured KMS key of uploaded objects, which
+	// HeadObject returns as an ARN even if it was configured as an ID or alias.
+	kmsKeyMetadata = "Sse-Kms-Key-Id"
+)
+
+// sniffLength is the number of bytes the content type of files without a known extension is
+// detected from.
+const sniffLength = 512
+
+// compressibleTypes are the content types, besides text, which are pre-compressed.
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/wasm":       true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// newUploadInput returns the input uploading the file to the static S3 bucket, with its content
+// type, the given cache policy and the configured encryption and ACL, compressed if
+// allowCompression is true and compression is configured. It returns the number of bytes uploaded.
+//
+// Uploads are encrypted with StaticSSE, either AES256 or aws:kms with the StaticSSEKMSKeyID key,
+// granted the StaticACL canned ACL, and compressed with StaticCompression, either gzip or br.
+// Compressed objects are stored with a Content-Encoding, so StaticCompression must only be set if
+// every client of the static bucket decodes it, such as browsers behind a CDN. The Apps plugin
+// downloads objects with the S3 downloader, which does not, so the manifests it reads are never
+// compressed.
+func newUploadInput(key string, file *os.File, cacheControl string, allowCompression bool) (*s3manager.UploadInput, int64, error) {
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to read %s", file.Name())
+	}
+
+	input := &s3manager.UploadInput{
+		Bucket:       aws.String(os.Getenv("StaticBucket")),
+		Key:          aws.String(key),
+		ContentType:  aws.String(detectContentType(file.Name(), content)),
+		CacheControl: aws.String(cacheControl),
+	}
+
+	switch sse := os.Getenv("StaticSSE"); sse {
+	case "":
+	case s3.ServerSideEncryptionAes256:
+		input.ServerSideEncryption = aws.String(sse)
+	case s3.ServerSideEncryptionAwsKms:
+		input.ServerSideEncryption = aws.String(sse)
+		if os.Getenv("StaticSSEKMSKeyID") != "" {
+			input.SSEKMSKeyId = aws.String(os.Getenv("StaticSSEKMSKeyID"))
+		}
+	default:
+		return nil, 0, errors.Errorf("StaticSSE must be %s or %s, got %s", s3.ServerSideEncryptionAes256, s3.ServerSideEncryptionAwsKms, sse)
+	}
+
+	input.Metadata = map[string]*string{}
+	if input.SSEKMSKeyId != nil {
+		input.Metadata[kmsKeyMetadata] = input.SSEKMSKeyId
+	}
+
+	if os.Getenv("StaticACL") != "" {
+		input.ACL = aws.String(os.Getenv("StaticACL"))
+		input.Metadata[aclMetadata] = input.ACL
+	}
+
+	encoding := os.Getenv("StaticCompression")
+	if allowCompression && encoding != "" && isCompressible(aws.StringValue(input.ContentType)) {
+		content, err = compress(encoding, content)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to compress %s", file.Name())
+		}
+		input.ContentEncoding = aws.String(encoding)
+	}
+	input.Body = bytes.NewReader(content)
+
+	md5Sum := md5.Sum(content)
+	sha256Sum := sha256.Sum256(content)
+	input.ContentMD5 = aws.String(base64.StdEncoding.EncodeToString(md5Sum[:]))
+	input.Metadata[checksumMetadata] = aws.String(hex.EncodeToString(sha256Sum[:]))
+
+	return input, int64(len(content)), nil
+}
+
+// isUnchanged returns true if the existing object holds the content of the upload, according to
+// the SHA-256 checksum recorded in its metadata, and was uploaded with the same content type,
+// cache policy, encoding, encryption and ACL. The ACL and KMS key are compared through the
+// metadata recorded on upload, as HeadObject does not return them as configured. The encryption
+// is only compared if StaticSSE is set, as objects are otherwise encrypted with the default
+// encryption of the bucket.
+// Objects without the recorded metadata, such as the ones uploaded by previous versions of the
+// deployer, are never unchanged, so that they are uploaded again with the configured settings.
+func isUnchanged(input *s3manager.UploadInput, existing *s3.HeadObjectOutput) bool {
+	if aws.StringValue(existing.ContentType) != aws.StringValue(input.ContentType) ||
+		aws.StringValue(existing.CacheControl) != aws.StringValue(input.CacheControl) ||
+		aws.StringValue(existing.ContentEncoding) != aws.StringValue(input.ContentEncoding) {
+		return false
+	}
+
+	if input.ServerSideEncryption != nil && aws.StringValue(existing.ServerSideEncryption) != aws.StringValue(input.ServerSideEncryption) {
+		return false
+	}
+
+	if len(existing.Metadata) != len(input.Metadata) {
+		return false
+	}
+	for key, value := range input.Metadata {
+		if aws.StringValue(existing.Metadata[key]) != aws.StringValue(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// uploadConcurrency returns the number of static files uploaded at once.
+func uploadConcurrency() (int, error) {
+	if os.Getenv("StaticUploadConcurrency") == "" {
+		return defaultUploadConcurrency, nil
+	}
+
+	concurrency, err := strconv.Atoi(os.Getenv("StaticUploadConcurrency"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse StaticUploadConcurrency")
+	}
+	if concurrency < 1 {
+		return 0, errors.Errorf("StaticUploadConcurrency must be at least 1, got %d", concurrency)
+	}
+
+	return concurrency, nil
+}
+
+// staticCacheControl returns the cache policy of the static assets.
+func staticCacheControl() string {
+	if os.Getenv("StaticCacheControl") != "" {
+		return os.Getenv("StaticCacheControl")
+	}
+
+	return defaultStaticCacheControl
+}
+
+// manifestCacheControl returns the cache policy of the manifests.
+func manifestCacheControl() string {
+	if os.Getenv("ManifestCacheControl") != "" {
+		return os.Getenv("ManifestCacheControl")
+	}
+
+	return defaultManifestCacheControl
+}
+
+// detectContentType returns the MIME type of a file from its extension, or detected from its
+// content if the extension is unknown.
+func detectContentType(name string, content []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+
+	if len(content) > sniffLength {
+		content = content[:sniffLength]
+	}
+
+	return http.DetectContentType(content)
+}
+
+// isCompressible returns true if files of the content type are worth pre-compressing.
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType]
+}
+
+// compress compresses the content with the given encoding, either gzip or br.
+func compress(encoding string, content []byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case encodingGzip:
+		writer = gzip.NewWriter(&buffer)
+	case encodingBrotli:
+		writer = brotli.NewWriter(&buffer)
+	default:
+		return nil, errors.Errorf("unsupported compression %s, expected %s or %s", encoding, encodingGzip, encodingBrotli)
+	}
+
+	_, err := writer.Write(content)
+	if err != nil {
+		return nil, err
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
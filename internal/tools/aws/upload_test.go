@@ -0,0 +1,177 @@
+package aws
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectContentType(t *testing.T) {
+	assert.Equal(t, "image/png", detectContentType("static/icon.png", nil))
+	assert.Equal(t, "text/css; charset=utf-8", detectContentType("static/app.css", nil))
+	assert.Equal(t, "application/json", detectContentType("manifest.json", nil))
+	assert.Equal(t, "text/plain; charset=utf-8", detectContentType("static/LICENSE", []byte("MIT License")))
+	assert.Equal(t, "image/png", detectContentType("static/icon", []byte("\x89PNG\x0D\x0A\x1A\x0A")))
+}
+
+func TestIsCompressible(t *testing.T) {
+	assert.True(t, isCompressible("text/css; charset=utf-8"))
+	assert.True(t, isCompressible("application/json"))
+	assert.True(t, isCompressible("image/svg+xml"))
+	assert.False(t, isCompressible("image/png"))
+	assert.False(t, isCompressible(""))
+}
+
+func TestCompress(t *testing.T) {
+	content := bytes.Repeat([]byte("mattermost apps "), 100)
+
+	compressed, err := compress(encodingGzip, content)
+	require.NoError(t, err)
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content, decompressed)
+
+	compressed, err = compress(encodingBrotli, content)
+	require.NoError(t, err)
+	decompressed, err = io.ReadAll(brotli.NewReader(bytes.NewReader(compressed)))
+	require.NoError(t, err)
+	assert.Equal(t, content, decompressed)
+
+	_, err = compress("zstd", content)
+	assert.Error(t, err)
+}
+
+func TestNewUploadInput(t *testing.T) {
+	t.Setenv("StaticBucket", "apps-static")
+	dir := t.TempDir()
+	content := bytes.Repeat([]byte("body { color: red; }\n"), 100)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.css"), content, 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "icon.png"), []byte("\x89PNG\x0D\x0A\x1A\x0A"), 0600))
+
+	open := func(name string) *os.File {
+		file, err := os.Open(filepath.Join(dir, name))
+		require.NoError(t, err)
+		t.Cleanup(func() { file.Close() })
+		return file
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		input, size, err := newUploadInput("static/app.css", open("app.css"), staticCacheControl(), true)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), size)
+		assert.Equal(t, "apps-static", aws.StringValue(input.Bucket))
+		assert.Equal(t, "text/css; charset=utf-8", aws.StringValue(input.ContentType))
+		assert.Equal(t, defaultStaticCacheControl, aws.StringValue(input.CacheControl))
+		assert.Nil(t, input.ServerSideEncryption)
+		assert.Nil(t, input.ContentEncoding)
+	})
+
+	t.Run("encrypted and compressed", func(t *testing.T) {
+		t.Setenv("StaticSSE", "aws:kms")
+		t.Setenv("StaticSSEKMSKeyID", "alias/apps")
+		t.Setenv("StaticCompression", "gzip")
+		t.Setenv("ManifestCacheControl", "no-cache")
+
+		input, size, err := newUploadInput("static/app.css", open("app.css"), staticCacheControl(), true)
+		require.NoError(t, err)
+		assert.Less(t, size, int64(len(content)))
+		assert.Equal(t, "aws:kms", aws.StringValue(input.ServerSideEncryption))
+		assert.Equal(t, "alias/apps", aws.StringValue(input.SSEKMSKeyId))
+		assert.Equal(t, "gzip", aws.StringValue(input.ContentEncoding))
+
+		// Manifests are not compressed.
+		input, size, err = newUploadInput("manifests/manifest.json", open("app.css"), manifestCacheControl(), false)
+		require.NoError(t, err)
+		assert.Equal(t, int64(len(content)), size)
+		assert.Equal(t, "aws:kms", aws.StringValue(input.ServerSideEncryption))
+		assert.Nil(t, input.ContentEncoding)
+		assert.Equal(t, "no-cache", aws.StringValue(input.CacheControl))
+
+		// Binary files are not compressed.
+		input, _, err = newUploadInput("static/icon.png", open("icon.png"), staticCacheControl(), true)
+		require.NoError(t, err)
+		assert.Nil(t, input.ContentEncoding)
+	})
+
+	t.Run("invalid encryption", func(t *testing.T) {
+		t.Setenv("StaticSSE", "rot13")
+		_, _, err := newUploadInput("static/app.css", open("app.css"), staticCacheControl(), true)
+		assert.Error(t, err)
+	})
+}
+
+func TestIsUnchanged(t *testing.T) {
+	t.Setenv("StaticBucket", "apps-static")
+	t.Setenv("StaticSSE", "aws:kms")
+	t.Setenv("StaticSSEKMSKeyID", "alias/apps")
+	t.Setenv("StaticACL", "public-read")
+	file := filepath.Join(t.TempDir(), "app.js")
+	require.NoError(t, os.WriteFile(file, []byte("console.log('hello');"), 0600))
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+
+	input, _, err := newUploadInput("static/app.js", f, staticCacheControl(), true)
+	require.NoError(t, err)
+	require.Len(t, aws.StringValue(input.Metadata[checksumMetadata]), 64)
+	assert.Equal(t, "public-read", aws.StringValue(input.Metadata[aclMetadata]))
+	assert.Equal(t, "alias/apps", aws.StringValue(input.Metadata[kmsKeyMetadata]))
+
+	// existing returns the object as uploaded with the input, modified by update.
+	existing := func(update func(*s3.HeadObjectOutput)) *s3.HeadObjectOutput {
+		output := &s3.HeadObjectOutput{
+			ContentType:          input.ContentType,
+			CacheControl:         input.CacheControl,
+			ServerSideEncryption: input.ServerSideEncryption,
+			SSEKMSKeyId:          aws.String("arn:aws:kms:us-east-1:123456789012:key/apps"),
+			Metadata:             map[string]*string{},
+		}
+		for key, value := range input.Metadata {
+			output.Metadata[key] = value
+		}
+		update(output)
+		return output
+	}
+
+	assert.True(t, isUnchanged(input, existing(func(*s3.HeadObjectOutput) {})))
+
+	for name, update := range map[string]func(*s3.HeadObjectOutput){
+		"content":          func(o *s3.HeadObjectOutput) { o.Metadata[checksumMetadata] = aws.String("other") },
+		"content type":     func(o *s3.HeadObjectOutput) { o.ContentType = aws.String("text/plain") },
+		"cache control":    func(o *s3.HeadObjectOutput) { o.CacheControl = aws.String("no-cache") },
+		"content encoding": func(o *s3.HeadObjectOutput) { o.ContentEncoding = aws.String("gzip") },
+		"encryption":       func(o *s3.HeadObjectOutput) { o.ServerSideEncryption = aws.String("AES256") },
+		"kms key":          func(o *s3.HeadObjectOutput) { o.Metadata[kmsKeyMetadata] = aws.String("alias/other") },
+		"acl":              func(o *s3.HeadObjectOutput) { delete(o.Metadata, aclMetadata) },
+		// Objects uploaded by previous versions of the deployer have no recorded metadata.
+		"no metadata": func(o *s3.HeadObjectOutput) { o.Metadata = nil },
+	} {
+		assert.False(t, isUnchanged(input, existing(update)), name)
+	}
+}
+
+func TestUploadConcurrency(t *testing.T) {
+	concurrency, err := uploadConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, defaultUploadConcurrency, concurrency)
+
+	t.Setenv("StaticUploadConcurrency", "2")
+	concurrency, err = uploadConcurrency()
+	require.NoError(t, err)
+	assert.Equal(t, 2, concurrency)
+
+	t.Setenv("StaticUploadConcurrency", "0")
+	_, err = uploadConcurrency()
+	assert.Error(t, err)
+}
@@ -7,18 +7,41 @@ package exechelper
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base32"
 	"io"
 	"math/rand"
 	"os/exec"
+	"path/filepath"
 	"sync"
 	"time"
 
 	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
 	"github.com/pborman/uuid"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
+var tracer = otel.Tracer("github.com/mattermost/mattermost-apps/internal/tools/exechelper")
+
+// Error is returned when an invoked command fails, and carries the STDERR output of the command.
+type Error struct {
+	Err    error
+	Stderr []byte
+}
+
+// Error returns the error message.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 // OutputLogger allows custom logging of the run command output.
 type OutputLogger func(line string, logger appsutils.Logger)
 
@@ -56,9 +79,22 @@ func bufferAndLog(reader io.Reader, buffer *bytes.Buffer, logger appsutils.Logge
 
 // Run invokes cmd.Run, both logging and returning STDOUT and STDERR, optionally transforming the output first.
 func Run(cmd *exec.Cmd, logger appsutils.Logger, outputLogger OutputLogger) ([]byte, []byte, error) {
+	return RunContext(context.Background(), cmd, logger, outputLogger)
+}
+
+// RunContext is like Run, and traces the command invocation as a child span of the span in ctx.
+func RunContext(ctx context.Context, cmd *exec.Cmd, logger appsutils.Logger, outputLogger OutputLogger) ([]byte, []byte, error) {
 	// Generate a unique identifier for the command invocation by which to group logs.
 	runID := NewID()
 
+	_, span := tracer.Start(ctx, "exec "+filepath.Base(cmd.Path))
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("run", runID),
+		attribute.String("cmd", cmd.Path),
+		attribute.StringSlice("args", cmd.Args),
+	)
+
 	logger = logger.With(
 		"run", runID,
 	)
@@ -107,8 +143,13 @@ func Run(cmd *exec.Cmd, logger appsutils.Logger, outputLogger OutputLogger) ([]b
 
 	if err != nil {
 		logger.WithError(err).Errorf("failed invocation")
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "failed invocation")
 
-		return stdout.Bytes(), stderr.Bytes(), errors.Wrap(err, "failed invocation")
+		return stdout.Bytes(), stderr.Bytes(), &Error{
+			Err:    errors.Wrap(err, "failed invocation"),
+			Stderr: stderr.Bytes(),
+		}
 	}
 
 	return stdout.Bytes(), stderr.Bytes(), nil
@@ -0,0 +1,110 @@
+package lock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pkg/errors"
+)
+
+// DynamoDBLocker stores the locks as items of a DynamoDB table with the LockID string partition
+// key, the same schema as the Terraform state lock tables. Locks are taken with conditional
+// writes, and their ExpiresAt attribute can be used as the table TTL attribute to clean up the
+// locks of crashed deployers.
+type DynamoDBLocker struct {
+	table string
+	svc   *dynamodb.DynamoDB
+}
+
+// NewDynamoDBLocker creates a locker storing the locks in the given DynamoDB table.
+func NewDynamoDBLocker(table string, session *session.Session) *DynamoDBLocker {
+	return &DynamoDBLocker{table: table, svc: dynamodb.New(session)}
+}
+
+// Acquire puts the lock item, unless another owner holds an unexpired lock.
+func (l *DynamoDBLocker) Acquire(ctx context.Context, name, owner string, expiresAt time.Time) error {
+	_, err := l.svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"LockID":    {S: aws.String(name)},
+			"Owner":     {S: aws.String(owner)},
+			"ExpiresAt": unixTime(expiresAt),
+		},
+		ConditionExpression: aws.String("attribute_not_exists(LockID) OR #owner = :owner OR ExpiresAt < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+			":now":   unixTime(time.Now()),
+		},
+	})
+
+	return lockError(err, "acquire", name)
+}
+
+// Renew updates the expiry time of the lock item of the owner.
+func (l *DynamoDBLocker) Renew(ctx context.Context, name, owner string, expiresAt time.Time) error {
+	_, err := l.svc.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(name)},
+		},
+		UpdateExpression:    aws.String("SET ExpiresAt = :expires_at"),
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner":      {S: aws.String(owner)},
+			":expires_at": unixTime(expiresAt),
+		},
+	})
+
+	return lockError(err, "renew", name)
+}
+
+// Release deletes the lock item of the owner.
+func (l *DynamoDBLocker) Release(ctx context.Context, name, owner string) error {
+	_, err := l.svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(l.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"LockID": {S: aws.String(name)},
+		},
+		ConditionExpression: aws.String("#owner = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#owner": aws.String("Owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+
+	// The lock was already taken over by another owner, or removed.
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+
+	return lockError(err, "release", name)
+}
+
+// lockError returns ErrLocked if the condition of the write failed.
+func lockError(err error, action, name string) error {
+	if err == nil {
+		return nil
+	}
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrLocked
+	}
+
+	return errors.Wrapf(err, "failed to %s lock %s", action, name)
+}
+
+func unixTime(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
@@ -0,0 +1,154 @@
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// guardRetryInterval is the interval the guard of a lock file is retried at while another
+	// process holds it.
+	guardRetryInterval = 10 * time.Millisecond
+	// guardTimeout is the age after which the guard of a lock file is considered left over by a
+	// process which crashed, and removed.
+	guardTimeout = 10 * time.Second
+)
+
+// FileLocker stores each lock as a JSON file of a directory. It stands in for DynamoDB when
+// running the deployer locally, and only excludes the deployers sharing the directory.
+type FileLocker struct {
+	dir string
+}
+
+// fileLock is the content of a lock file.
+type fileLock struct {
+	Owner     string    `json:"owner"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// NewFileLocker creates a locker storing the locks in the given directory.
+func NewFileLocker(dir string) (*FileLocker, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create lock directory")
+	}
+
+	return &FileLocker{dir: dir}, nil
+}
+
+// Acquire writes the lock file, unless another owner holds an unexpired lock.
+func (l *FileLocker) Acquire(ctx context.Context, name, owner string, expiresAt time.Time) error {
+	return l.update(ctx, name, func(current *fileLock) (*fileLock, error) {
+		if current != nil && current.Owner != owner && time.Now().Before(current.ExpiresAt) {
+			return nil, ErrLocked
+		}
+		return &fileLock{Owner: owner, ExpiresAt: expiresAt}, nil
+	})
+}
+
+// Renew rewrites the lock file of the owner with the new expiry time.
+func (l *FileLocker) Renew(ctx context.Context, name, owner string, expiresAt time.Time) error {
+	return l.update(ctx, name, func(current *fileLock) (*fileLock, error) {
+		if current == nil || current.Owner != owner {
+			return nil, ErrLocked
+		}
+		return &fileLock{Owner: owner, ExpiresAt: expiresAt}, nil
+	})
+}
+
+// Release removes the lock file of the owner.
+func (l *FileLocker) Release(ctx context.Context, name, owner string) error {
+	return l.update(ctx, name, func(current *fileLock) (*fileLock, error) {
+		if current == nil || current.Owner != owner {
+			return current, nil
+		}
+		return nil, nil
+	})
+}
+
+// update replaces the lock file with the result of the change, removing it if the result is nil.
+// Lock files are changed under a guard file created exclusively, so that concurrent processes
+// read and write them one at a time.
+func (l *FileLocker) update(ctx context.Context, name string, change func(current *fileLock) (*fileLock, error)) error {
+	lockPath := filepath.Join(l.dir, url.PathEscape(name)+".json")
+
+	release, err := l.guard(ctx, lockPath+".guard")
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var current *fileLock
+	content, err := os.ReadFile(lockPath)
+	switch {
+	case err == nil:
+		current = &fileLock{}
+		err = json.Unmarshal(content, current)
+		if err != nil {
+			return errors.Wrapf(err, "failed to parse lock %s", name)
+		}
+	case !os.IsNotExist(err):
+		return errors.Wrapf(err, "failed to read lock %s", name)
+	}
+
+	next, err := change(current)
+	if err != nil {
+		return err
+	}
+
+	if next == nil {
+		err = os.Remove(lockPath)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to remove lock %s", name)
+		}
+		return nil
+	}
+
+	content, err = json.Marshal(next)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal lock %s", name)
+	}
+	err = os.WriteFile(lockPath+".tmp", content, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write lock %s", name)
+	}
+	err = os.Rename(lockPath+".tmp", lockPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to write lock %s", name)
+	}
+
+	return nil
+}
+
+// guard creates the guard file, waiting while another process holds it, and returns the function
+// removing it.
+func (l *FileLocker) guard(ctx context.Context, guardPath string) (func(), error) {
+	for {
+		file, err := os.OpenFile(guardPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			_ = file.Close()
+			return func() { _ = os.Remove(guardPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, errors.Wrap(err, "failed to create lock guard")
+		}
+
+		info, err := os.Stat(guardPath)
+		if err == nil && time.Since(info.ModTime()) > guardTimeout {
+			_ = os.Remove(guardPath)
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Wrap(ctx.Err(), "timed out waiting for lock guard")
+		case <-time.After(guardRetryInterval):
+		}
+	}
+}
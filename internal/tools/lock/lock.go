@@ -0,0 +1,116 @@
+// Package lock provides the lease locks deployers claim runs and bundles with, so that concurrent
+// deployers never deploy the same bundle twice.
+//
+// A lease expires after its TTL unless it is renewed, so that the locks of a deployer which
+// crashed are released. Leases are renewed by a heartbeat while they are held.
+package lock
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrLocked is returned when the lock is held by another owner.
+var ErrLocked = errors.New("lock is held by another owner")
+
+// Locker stores the locks.
+type Locker interface {
+	// Acquire takes the lock for the owner until the expiry time, unless another owner holds it
+	// and it has not expired, in which case it returns ErrLocked.
+	Acquire(ctx context.Context, name, owner string, expiresAt time.Time) error
+	// Renew extends the lock of the owner until the expiry time, or returns ErrLocked if the
+	// owner does not hold it anymore.
+	Renew(ctx context.Context, name, owner string, expiresAt time.Time) error
+	// Release removes the lock if the owner holds it.
+	Release(ctx context.Context, name, owner string) error
+}
+
+// Lease is a lock held by an owner, renewed every third of its TTL until it is released.
+type Lease struct {
+	locker Locker
+	name   string
+	owner  string
+	ttl    time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+	lost chan struct{}
+	once sync.Once
+}
+
+// Acquire takes the lock for the owner, and starts renewing it. It returns ErrLocked if the lock
+// is held by another owner.
+func Acquire(ctx context.Context, locker Locker, name, owner string, ttl time.Duration) (*Lease, error) {
+	if ttl <= 0 {
+		return nil, errors.Errorf("invalid lease TTL %s", ttl)
+	}
+
+	err := locker.Acquire(ctx, name, owner, time.Now().Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{
+		locker: locker,
+		name:   name,
+		owner:  owner,
+		ttl:    ttl,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+		lost:   make(chan struct{}),
+	}
+	go lease.heartbeat()
+
+	return lease, nil
+}
+
+// Name returns the name of the lock.
+func (l *Lease) Name() string {
+	return l.name
+}
+
+// Lost is closed if the lease could not be renewed before it expired, in which case another owner
+// may take the lock.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Release stops renewing the lease and removes the lock.
+func (l *Lease) Release(ctx context.Context) error {
+	l.once.Do(func() { close(l.stop) })
+	<-l.done
+
+	return l.locker.Release(ctx, l.name, l.owner)
+}
+
+func (l *Lease) heartbeat() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.ttl / 3)
+	defer ticker.Stop()
+
+	renewedAt := time.Now()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), l.ttl/3)
+		now := time.Now()
+		err := l.locker.Renew(ctx, l.name, l.owner, now.Add(l.ttl))
+		cancel()
+
+		// Transient failures are retried until the lease expires.
+		if err == nil {
+			renewedAt = now
+		} else if errors.Is(err, ErrLocked) || time.Since(renewedAt) >= l.ttl {
+			close(l.lost)
+			return
+		}
+	}
+}
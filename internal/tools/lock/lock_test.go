@@ -0,0 +1,104 @@
+package lock
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileLocker(t *testing.T) {
+	ctx := context.Background()
+	locker, err := NewFileLocker(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, locker.Acquire(ctx, "test/bundles/hello.zip", "first", time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, locker.Acquire(ctx, "test/bundles/hello.zip", "second", time.Now().Add(time.Minute)), ErrLocked)
+	assert.NoError(t, locker.Acquire(ctx, "test/bundles/other.zip", "second", time.Now().Add(time.Minute)))
+
+	require.NoError(t, locker.Renew(ctx, "test/bundles/hello.zip", "first", time.Now().Add(time.Minute)))
+	assert.ErrorIs(t, locker.Renew(ctx, "test/bundles/hello.zip", "second", time.Now().Add(time.Minute)), ErrLocked)
+
+	// Releasing the lock of another owner is a no-op.
+	require.NoError(t, locker.Release(ctx, "test/bundles/hello.zip", "second"))
+	assert.ErrorIs(t, locker.Acquire(ctx, "test/bundles/hello.zip", "second", time.Now().Add(time.Minute)), ErrLocked)
+
+	require.NoError(t, locker.Release(ctx, "test/bundles/hello.zip", "first"))
+	assert.NoError(t, locker.Acquire(ctx, "test/bundles/hello.zip", "second", time.Now().Add(time.Minute)))
+
+	t.Run("expired lock", func(t *testing.T) {
+		require.NoError(t, locker.Acquire(ctx, "test/run", "first", time.Now().Add(-time.Second)))
+		assert.NoError(t, locker.Acquire(ctx, "test/run", "second", time.Now().Add(time.Minute)))
+		assert.ErrorIs(t, locker.Renew(ctx, "test/run", "first", time.Now().Add(time.Minute)), ErrLocked)
+	})
+
+	t.Run("concurrent owners", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var lock sync.Mutex
+		acquired := 0
+		for _, owner := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+			wg.Add(1)
+			go func(owner string) {
+				defer wg.Done()
+				if locker.Acquire(ctx, "test/concurrent", owner, time.Now().Add(time.Minute)) == nil {
+					lock.Lock()
+					acquired++
+					lock.Unlock()
+				}
+			}(owner)
+		}
+		wg.Wait()
+		assert.Equal(t, 1, acquired)
+	})
+}
+
+func TestLease(t *testing.T) {
+	ctx := context.Background()
+	locker, err := NewFileLocker(t.TempDir())
+	require.NoError(t, err)
+
+	t.Run("heartbeat", func(t *testing.T) {
+		lease, err := Acquire(ctx, locker, "test/run", "first", 150*time.Millisecond)
+		require.NoError(t, err)
+
+		_, err = Acquire(ctx, locker, "test/run", "second", time.Minute)
+		assert.ErrorIs(t, err, ErrLocked)
+
+		// The lease is renewed past its TTL.
+		time.Sleep(300 * time.Millisecond)
+		_, err = Acquire(ctx, locker, "test/run", "second", time.Minute)
+		assert.ErrorIs(t, err, ErrLocked)
+
+		require.NoError(t, lease.Release(ctx))
+		second, err := Acquire(ctx, locker, "test/run", "second", time.Minute)
+		require.NoError(t, err)
+		require.NoError(t, second.Release(ctx))
+	})
+
+	t.Run("lost", func(t *testing.T) {
+		lease, err := Acquire(ctx, locker, "test/lost", "first", 150*time.Millisecond)
+		require.NoError(t, err)
+
+		// Another owner takes the lock over, as if the lease had expired.
+		require.NoError(t, locker.Release(ctx, "test/lost", "first"))
+		require.NoError(t, locker.Acquire(ctx, "test/lost", "second", time.Now().Add(time.Minute)))
+
+		select {
+		case <-lease.Lost():
+		case <-time.After(time.Second):
+			t.Fatal("lease was not lost")
+		}
+
+		// Releasing a lost lease keeps the lock of the new owner.
+		require.NoError(t, lease.Release(ctx))
+		assert.ErrorIs(t, locker.Acquire(ctx, "test/lost", "third", time.Now().Add(time.Minute)), ErrLocked)
+	})
+
+	t.Run("invalid TTL", func(t *testing.T) {
+		_, err := Acquire(ctx, locker, "test/invalid", "first", 0)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileQueue is a queue backed by a directory, where each JSON file is a message. It stands in for
+// SQS when running the deployer locally.
+type FileQueue struct {
+	dir          string
+	pollInterval time.Duration
+	visibility   *visibility
+}
+
+// NewFileQueue creates a queue reading messages from the given directory every poll interval.
+func NewFileQueue(dir string, pollInterval time.Duration) (*FileQueue, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create queue directory")
+	}
+
+	return &FileQueue{dir: dir, pollInterval: pollInterval, visibility: newVisibility()}, nil
+}
+
+// Receive returns the visible messages in the directory, waiting for the poll interval if there
+// is none.
+func (q *FileQueue) Receive(ctx context.Context) ([]Message, error) {
+	messages, err := q.read()
+	if err != nil {
+		return nil, err
+	}
+	if messages = q.visibility.receive(messages); len(messages) > 0 {
+		return messages, nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil, nil
+	case <-time.After(q.pollInterval):
+	}
+
+	messages, err = q.read()
+	if err != nil {
+		return nil, err
+	}
+
+	return q.visibility.receive(messages), nil
+}
+
+func (q *FileQueue) read() ([]Message, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read queue directory")
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	var messages []Message
+	for _, name := range names {
+		body, err := os.ReadFile(filepath.Join(q.dir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read message %s", name)
+		}
+		messages = append(messages, Message{ID: name, ReceiptHandle: name, Body: body})
+	}
+
+	return messages, nil
+}
+
+// Delete removes the message file.
+func (q *FileQueue) Delete(ctx context.Context, message Message) error {
+	err := os.Remove(filepath.Join(q.dir, message.ReceiptHandle))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to delete message %s", message.ID)
+	}
+	q.visibility.forget(message)
+
+	return nil
+}
+
+// ChangeVisibility hides the message file for the given timeout.
+func (q *FileQueue) ChangeVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	q.visibility.hide(message, timeout)
+	return nil
+}
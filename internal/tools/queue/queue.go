@@ -0,0 +1,144 @@
+// Package queue provides the message queues the deployer consumes bundle upload events from.
+//
+// Messages which are not deleted after being received are delivered again, so that failed
+// deployments are retried, or moved to a dead-letter queue by the queue redrive policy. Changing
+// the visibility of a message hides it from Receive, either while it is processed, or to delay its
+// redelivery.
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Message is a message received from a queue.
+type Message struct {
+	ID            string
+	ReceiptHandle string
+	Body          []byte
+	// ReceiveCount is the number of times the message was received, including this one.
+	ReceiveCount int
+}
+
+// Queue is a queue of messages.
+type Queue interface {
+	// Receive waits for messages, returning no messages if none arrived before the wait time or
+	// the context is done.
+	Receive(ctx context.Context) ([]Message, error)
+	// Delete removes a message received from the queue so that it is not delivered again.
+	Delete(ctx context.Context, message Message) error
+	// ChangeVisibility hides a received message from Receive for the given timeout from now.
+	ChangeVisibility(ctx context.Context, message Message, timeout time.Duration) error
+}
+
+// MemoryQueue is an in-memory queue. Messages are delivered again on every Receive until they
+// are deleted, unless they are hidden.
+type MemoryQueue struct {
+	lock       sync.Mutex
+	messages   []Message
+	visibility *visibility
+}
+
+// NewMemoryQueue creates an in-memory queue.
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{visibility: newVisibility()}
+}
+
+// Send adds a message to the queue.
+func (q *MemoryQueue) Send(message Message) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if message.ReceiptHandle == "" {
+		message.ReceiptHandle = message.ID
+	}
+	q.messages = append(q.messages, message)
+}
+
+// Len returns the number of messages in the queue.
+func (q *MemoryQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return len(q.messages)
+}
+
+// Receive returns the visible messages of the queue.
+func (q *MemoryQueue) Receive(ctx context.Context) ([]Message, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.visibility.receive(q.messages), nil
+}
+
+// Delete removes the message from the queue.
+func (q *MemoryQueue) Delete(ctx context.Context, message Message) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for i := range q.messages {
+		if q.messages[i].ReceiptHandle == message.ReceiptHandle {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+			break
+		}
+	}
+	q.visibility.forget(message)
+
+	return nil
+}
+
+// ChangeVisibility hides the message for the given timeout.
+func (q *MemoryQueue) ChangeVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	q.visibility.hide(message, timeout)
+	return nil
+}
+
+// visibility tracks the messages hidden from Receive, and the number of times each message was
+// received, for the queues which do not track them.
+type visibility struct {
+	lock          sync.Mutex
+	hiddenUntil   map[string]time.Time
+	receiveCounts map[string]int
+}
+
+func newVisibility() *visibility {
+	return &visibility{
+		hiddenUntil:   map[string]time.Time{},
+		receiveCounts: map[string]int{},
+	}
+}
+
+// receive returns the messages which are not hidden, with their receive count incremented.
+func (v *visibility) receive(messages []Message) []Message {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	now := time.Now()
+	visible := []Message{}
+	for _, message := range messages {
+		if now.Before(v.hiddenUntil[message.ReceiptHandle]) {
+			continue
+		}
+		v.receiveCounts[message.ReceiptHandle]++
+		message.ReceiveCount = v.receiveCounts[message.ReceiptHandle]
+		visible = append(visible, message)
+	}
+
+	return visible
+}
+
+func (v *visibility) hide(message Message, timeout time.Duration) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.hiddenUntil[message.ReceiptHandle] = time.Now().Add(timeout)
+}
+
+func (v *visibility) forget(message Message) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.hiddenUntil, message.ReceiptHandle)
+	delete(v.receiveCounts, message.ReceiptHandle)
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryQueue(t *testing.T) {
+	q := NewMemoryQueue()
+	q.Send(Message{ID: "1", Body: []byte("first")})
+	q.Send(Message{ID: "2", Body: []byte("second")})
+
+	messages, err := q.Receive(context.Background())
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+
+	require.NoError(t, q.Delete(context.Background(), messages[0]))
+
+	// Messages which are not deleted are delivered again.
+	messages, err = q.Receive(context.Background())
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "second", string(messages[0].Body))
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestFileQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := NewFileQueue(dir, 10*time.Millisecond)
+	require.NoError(t, err)
+
+	messages, err := q.Receive(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "2.json"), []byte("second"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.json"), []byte("first"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ignored.txt"), []byte("ignored"), 0600))
+
+	messages, err = q.Receive(context.Background())
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	assert.Equal(t, "first", string(messages[0].Body))
+
+	require.NoError(t, q.Delete(context.Background(), messages[0]))
+	messages, err = q.Receive(context.Background())
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	assert.Equal(t, "second", string(messages[0].Body))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, q.Delete(context.Background(), messages[0]))
+	messages, err = q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, messages)
+}
+
+func TestQueueVisibility(t *testing.T) {
+	dir := t.TempDir()
+	fileQueue, err := NewFileQueue(dir, 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.json"), []byte("first"), 0600))
+	memoryQueue := NewMemoryQueue()
+	memoryQueue.Send(Message{ID: "1.json", Body: []byte("first")})
+
+	for name, q := range map[string]Queue{"file": fileQueue, "memory": memoryQueue} {
+		t.Run(name, func(t *testing.T) {
+			messages, err := q.Receive(context.Background())
+			require.NoError(t, err)
+			require.Len(t, messages, 1)
+			assert.Equal(t, 1, messages[0].ReceiveCount)
+
+			// Hidden messages are not delivered until their visibility timeout expires.
+			require.NoError(t, q.ChangeVisibility(context.Background(), messages[0], time.Hour))
+			hidden, err := q.Receive(context.Background())
+			require.NoError(t, err)
+			assert.Empty(t, hidden)
+
+			require.NoError(t, q.ChangeVisibility(context.Background(), messages[0], 0))
+			messages, err = q.Receive(context.Background())
+			require.NoError(t, err)
+			require.Len(t, messages, 1)
+			assert.Equal(t, 2, messages[0].ReceiveCount)
+		})
+	}
+}
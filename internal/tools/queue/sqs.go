@@ -0,0 +1,88 @@
+package queue
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// sqsWaitTimeSeconds is the long polling wait time of SQS receive requests.
+	sqsWaitTimeSeconds = 20
+	// sqsMaxMessages is the maximum number of messages received at once.
+	sqsMaxMessages = 10
+)
+
+// SQSQueue is an Amazon SQS queue. Messages which are not deleted are delivered again once their
+// visibility timeout expires, and moved to the dead-letter queue by the redrive policy.
+type SQSQueue struct {
+	url string
+	svc *sqs.SQS
+}
+
+// NewSQSQueue creates a client of the SQS queue with the given URL.
+func NewSQSQueue(url string, session *session.Session) *SQSQueue {
+	return &SQSQueue{url: url, svc: sqs.New(session)}
+}
+
+// Receive long polls the queue for messages.
+func (q *SQSQueue) Receive(ctx context.Context) ([]Message, error) {
+	result, err := q.svc.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(sqsMaxMessages),
+		WaitTimeSeconds:     aws.Int64(sqsWaitTimeSeconds),
+		AttributeNames:      []*string{aws.String(sqs.MessageSystemAttributeNameApproximateReceiveCount)},
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to receive SQS messages")
+	}
+
+	var messages []Message
+	for _, message := range result.Messages {
+		receiveCount, _ := strconv.Atoi(aws.StringValue(message.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+		messages = append(messages, Message{
+			ID:            aws.StringValue(message.MessageId),
+			ReceiptHandle: aws.StringValue(message.ReceiptHandle),
+			Body:          []byte(aws.StringValue(message.Body)),
+			ReceiveCount:  receiveCount,
+		})
+	}
+
+	return messages, nil
+}
+
+// Delete removes the message from the queue.
+func (q *SQSQueue) Delete(ctx context.Context, message Message) error {
+	_, err := q.svc.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: aws.String(message.ReceiptHandle),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete SQS message %s", message.ID)
+	}
+
+	return nil
+}
+
+// ChangeVisibility sets the visibility timeout of the message, so that it is not delivered to
+// another consumer before the timeout expires.
+func (q *SQSQueue) ChangeVisibility(ctx context.Context, message Message, timeout time.Duration) error {
+	_, err := q.svc.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     aws.String(message.ReceiptHandle),
+		VisibilityTimeout: aws.Int64(int64(timeout.Seconds())),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to change the visibility of SQS message %s", message.ID)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package terraform
 
 import (
+	"context"
 	"os/exec"
 
 	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
@@ -13,6 +14,7 @@ type Cmd struct {
 	dir               string
 	remoteStateBucket string
 	logger            appsutils.Logger
+	ctx               context.Context
 }
 
 // New creates a new instance of Cmd through which to execute terraform.
@@ -30,9 +32,19 @@ func New(dir, remoteStateBucket string, logger appsutils.Logger) (*Cmd, error) {
 		dir:               dir,
 		remoteStateBucket: remoteStateBucket,
 		logger:            logger,
+		ctx:               context.Background(),
 	}, nil
 }
 
+// WithContext returns a copy of the Cmd whose terraform invocations are traced as child spans of
+// the span in ctx, and killed if ctx is canceled.
+func (c *Cmd) WithContext(ctx context.Context) *Cmd {
+	cmd := *c
+	cmd.ctx = ctx
+
+	return &cmd
+}
+
 // GetWorkingDirectory returns the working directory used by terraform.
 func (c *Cmd) GetWorkingDirectory() string {
 	return c.dir
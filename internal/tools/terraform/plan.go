@@ -4,12 +4,18 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"strings"
 
 	model "github.com/mattermost/mattermost-apps/model"
 	"github.com/pkg/errors"
 )
 
+// destroyLambdaFile is the lambda_file variable of destroys. The zip file of a destroyed function
+// is usually not part of the bundle being deployed, as it was dropped from the app, and destroying
+// the function does not read it, so a placeholder is passed instead of a path which does not exist.
+// The module must therefore only read the file to create or update the function, and not for
+// instance to compute a source_code_hash.
+const destroyLambdaFile = "destroyed.zip"
+
 type terraformOutput struct {
 	Sensitive bool        `json:"sensitive"`
 	Type      string      `json:"type"`
@@ -31,9 +37,9 @@ func (c *Cmd) Init(remoteKey string) error {
 	return nil
 }
 
-// Plan invokes terraform Plan.
-func (c *Cmd) Plan(function model.Function) error {
-	_, _, err := c.run(
+// Plan invokes terraform Plan and returns the summary of the planned changes.
+func (c *Cmd) Plan(function model.Function) (*PlanSummary, error) {
+	stdout, _, err := c.run(
 		"plan",
 		arg("input", "false"),
 		arg("var", fmt.Sprintf("lambda_name=%s", function.Name)),
@@ -43,12 +49,13 @@ func (c *Cmd) Plan(function model.Function) error {
 		arg("var", fmt.Sprintf("handler=%s", function.Handler)),
 		arg("var", fmt.Sprintf("runtime=%s", function.Runtime)),
 		arg("var", fmt.Sprintf("private_subnet_ids=%s", os.Getenv("PrivateSubnetIDs"))),
+		arg("var", fmt.Sprintf("alias_name=%s", function.Alias)),
 	)
 	if err != nil {
-		return errors.Wrap(err, "failed to invoke terraform plan")
+		return nil, errors.Wrap(err, "failed to invoke terraform plan")
 	}
 
-	return nil
+	return ParsePlan(string(stdout)), nil
 }
 
 // Apply invokes terraform apply.
@@ -63,6 +70,7 @@ func (c *Cmd) Apply(function model.Function) error {
 		arg("var", fmt.Sprintf("handler=%s", function.Handler)),
 		arg("var", fmt.Sprintf("runtime=%s", function.Runtime)),
 		arg("var", fmt.Sprintf("private_subnet_ids=%s", os.Getenv("PrivateSubnetIDs"))),
+		arg("var", fmt.Sprintf("alias_name=%s", function.Alias)),
 		arg("auto-approve"),
 	)
 	if err != nil {
@@ -100,35 +108,87 @@ func (c *Cmd) Destroy() error {
 	return nil
 }
 
+// DestroyFunction invokes terraform destroy for the given lambda function.
+func (c *Cmd) DestroyFunction(function model.Function) error {
+	_, _, err := c.run(
+		"destroy",
+		arg("input", "false"),
+		arg("var", fmt.Sprintf("lambda_name=%s", function.Name)),
+		arg("var", fmt.Sprintf("lambda_file=%s", destroyLambdaFile)),
+		arg("var", fmt.Sprintf("environment=%s", function.Environment)),
+		arg("var", fmt.Sprintf("bundle_name=%s", function.BundleName)),
+		arg("var", fmt.Sprintf("handler=%s", function.Handler)),
+		arg("var", fmt.Sprintf("runtime=%s", function.Runtime)),
+		arg("var", fmt.Sprintf("private_subnet_ids=%s", os.Getenv("PrivateSubnetIDs"))),
+		arg("var", fmt.Sprintf("alias_name=%s", function.Alias)),
+		arg("auto-approve"),
+	)
+	if err != nil {
+		return errors.Wrap(err, "failed to invoke terraform destroy")
+	}
+
+	return nil
+}
+
+// PlanDestroy invokes terraform plan -destroy for the given lambda function and returns the
+// summary of the planned changes.
+func (c *Cmd) PlanDestroy(function model.Function) (*PlanSummary, error) {
+	stdout, _, err := c.run(
+		"plan",
+		arg("destroy"),
+		arg("input", "false"),
+		arg("var", fmt.Sprintf("lambda_name=%s", function.Name)),
+		arg("var", fmt.Sprintf("lambda_file=%s", destroyLambdaFile)),
+		arg("var", fmt.Sprintf("environment=%s", function.Environment)),
+		arg("var", fmt.Sprintf("bundle_name=%s", function.BundleName)),
+		arg("var", fmt.Sprintf("handler=%s", function.Handler)),
+		arg("var", fmt.Sprintf("runtime=%s", function.Runtime)),
+		arg("var", fmt.Sprintf("private_subnet_ids=%s", os.Getenv("PrivateSubnetIDs"))),
+		arg("var", fmt.Sprintf("alias_name=%s", function.Alias)),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to invoke terraform plan -destroy")
+	}
+
+	return ParsePlan(string(stdout)), nil
+}
+
 // Output invokes terraform output and returns the named value, true if it exists, and an empty
 // string and false if it does not.
 func (c *Cmd) Output(variable string) (string, bool, error) {
+	outputs, err := c.Outputs()
+	if err != nil {
+		return "", false, err
+	}
+
+	value, ok := outputs[variable]
+	if !ok {
+		return "", false, nil
+	}
+
+	return fmt.Sprintf("%v", value), true, nil
+}
+
+// Outputs invokes terraform output and returns all output values keyed by name.
+func (c *Cmd) Outputs() (map[string]interface{}, error) {
 	stdout, _, err := c.run(
 		"output",
 		"-json",
 	)
 	if err != nil {
-		return string(stdout), false, errors.Wrap(err, "failed to invoke terraform output")
+		return nil, errors.Wrap(err, "failed to invoke terraform output")
 	}
 
 	var outputs map[string]terraformOutput
 	err = json.Unmarshal(stdout, &outputs)
 	if err != nil {
-		return string(stdout), false, errors.Wrap(err, "failed to parse terraform output")
+		return nil, errors.Wrap(err, "failed to parse terraform output")
 	}
 
-	value, ok := outputs[variable]
-
-	return fmt.Sprintf("%s", value.Value), ok, nil
-}
-
-// Version invokes terraform version and returns the value.
-func (c *Cmd) Version() (string, error) {
-	stdout, _, err := c.run("version")
-	trimmed := strings.TrimSuffix(string(stdout), "\n")
-	if err != nil {
-		return trimmed, errors.Wrap(err, "failed to invoke terraform version")
+	values := make(map[string]interface{}, len(outputs))
+	for name, output := range outputs {
+		values[name] = output.Value
 	}
 
-	return trimmed, nil
+	return values, nil
 }
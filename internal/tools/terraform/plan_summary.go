@@ -0,0 +1,82 @@
+package terraform
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	planCountsRegexp   = regexp.MustCompile(`Plan: (\d+) to add, (\d+) to change, (\d+) to destroy`)
+	planResourceRegexp = regexp.MustCompile(`^\s*# (\S+) (?:\(.*\) )?(will be created|will be updated in-place|will be destroyed|must be replaced|will be read during apply)`)
+)
+
+var planActions = map[string]string{
+	"will be created":           "create",
+	"will be updated in-place":  "update",
+	"will be destroyed":         "destroy",
+	"must be replaced":          "replace",
+	"will be read during apply": "read",
+}
+
+// planStartMarkers mark the start of the relevant part of the terraform plan output.
+var planStartMarkers = []string{
+	"Terraform will perform the following actions:",
+	"No changes.",
+}
+
+// PlanSummary covers the resource changes of a terraform plan.
+type PlanSummary struct {
+	Add       int
+	Change    int
+	Destroy   int
+	Resources []string
+	Output    string
+}
+
+// HasChanges returns true if the plan changes any resource.
+func (p *PlanSummary) HasChanges() bool {
+	return p.Add > 0 || p.Change > 0 || p.Destroy > 0
+}
+
+// String returns the resource change counts of the plan.
+func (p *PlanSummary) String() string {
+	if !p.HasChanges() {
+		return "no changes"
+	}
+
+	return fmt.Sprintf("%d to add, %d to change, %d to destroy", p.Add, p.Change, p.Destroy)
+}
+
+// ParsePlan returns the summary of the given terraform plan output.
+func ParsePlan(output string) *PlanSummary {
+	summary := &PlanSummary{
+		Output: trimPlanOutput(output),
+	}
+
+	if match := planCountsRegexp.FindStringSubmatch(output); match != nil {
+		summary.Add, _ = strconv.Atoi(match[1])
+		summary.Change, _ = strconv.Atoi(match[2])
+		summary.Destroy, _ = strconv.Atoi(match[3])
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := planResourceRegexp.FindStringSubmatch(line); match != nil {
+			summary.Resources = append(summary.Resources, fmt.Sprintf("%s (%s)", match[1], planActions[match[2]]))
+		}
+	}
+
+	return summary
+}
+
+// trimPlanOutput drops the refresh logs preceding the planned actions.
+func trimPlanOutput(output string) string {
+	for _, marker := range planStartMarkers {
+		if i := strings.Index(output, marker); i >= 0 {
+			return strings.TrimSpace(output[i:])
+		}
+	}
+
+	return strings.TrimSpace(output)
+}
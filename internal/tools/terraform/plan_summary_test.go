@@ -0,0 +1,57 @@
+package terraform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePlan(t *testing.T) {
+	t.Run("changes", func(t *testing.T) {
+		output := `module.apps_deployment.data.aws_region.current: Reading...
+module.apps_deployment.aws_lambda_function.lambda_function: Refreshing state... [id=hello-world_v1-0-0_go-function]
+
+Terraform used the selected providers to generate the following execution plan.
+
+Terraform will perform the following actions:
+
+  # module.apps_deployment.aws_lambda_alias.lambda_alias will be created
+  + resource "aws_lambda_alias" "lambda_alias" {
+      + name = "live"
+    }
+
+  # module.apps_deployment.aws_lambda_function.lambda_function will be updated in-place
+  ~ resource "aws_lambda_function" "lambda_function" {
+      ~ publish = false -> true
+    }
+
+Plan: 1 to add, 1 to change, 0 to destroy.
+`
+
+		summary := ParsePlan(output)
+		assert.True(t, summary.HasChanges())
+		assert.Equal(t, 1, summary.Add)
+		assert.Equal(t, 1, summary.Change)
+		assert.Equal(t, 0, summary.Destroy)
+		assert.Equal(t, "1 to add, 1 to change, 0 to destroy", summary.String())
+		assert.Equal(t, []string{
+			"module.apps_deployment.aws_lambda_alias.lambda_alias (create)",
+			"module.apps_deployment.aws_lambda_function.lambda_function (update)",
+		}, summary.Resources)
+		assert.True(t, len(summary.Output) > 0)
+		assert.NotContains(t, summary.Output, "Refreshing state")
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		output := `module.apps_deployment.aws_lambda_function.lambda_function: Refreshing state...
+
+No changes. Your infrastructure matches the configuration.
+`
+
+		summary := ParsePlan(output)
+		assert.False(t, summary.HasChanges())
+		assert.Equal(t, "no changes", summary.String())
+		assert.Empty(t, summary.Resources)
+		assert.Equal(t, "No changes. Your infrastructure matches the configuration.", summary.Output)
+	})
+}
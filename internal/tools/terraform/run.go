@@ -34,9 +34,10 @@ func outputLogger(line string, logger appsutils.Logger) {
 }
 
 func (c *Cmd) run(arg ...string) ([]byte, []byte, error) {
-	cmd := exec.Command(c.terraformPath, append(arg, "-no-color")...)
+	// Terraform is killed if the context is canceled, e.g. when the deployment lost its lock.
+	cmd := exec.CommandContext(c.ctx, c.terraformPath, append(arg, "-no-color")...)
 	cmd.Dir = c.dir
 	cmd.Env = append(os.Environ(), "TF_IN_AUTOMATION=1")
 
-	return exechelper.Run(cmd, c.logger, outputLogger)
+	return exechelper.RunContext(c.ctx, cmd, c.logger, outputLogger)
 }
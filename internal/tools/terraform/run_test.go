@@ -1,9 +1,15 @@
 package terraform
 
 import (
+	"context"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestArg(t *testing.T) {
@@ -37,3 +43,17 @@ func TestArg(t *testing.T) {
 		})
 	}
 }
+
+func TestRunCanceled(t *testing.T) {
+	terraformPath := filepath.Join(t.TempDir(), "terraform")
+	require.NoError(t, os.WriteFile(terraformPath, []byte("#!/bin/sh\nexec sleep 30\n"), 0700))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := &Cmd{terraformPath: terraformPath, dir: t.TempDir(), logger: appsutils.NewTestLogger(), ctx: ctx}
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, _, err := cmd.run("apply")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 10*time.Second, "terraform is killed once the context is canceled")
+}
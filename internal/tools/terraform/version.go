@@ -0,0 +1,122 @@
+package terraform
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/go-version"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+)
+
+var (
+	terraformBlockSchema = &hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}},
+	}
+	requiredVersionSchema = &hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "required_version"}},
+	}
+)
+
+// VersionInfo covers the terraform binary and provider versions reported by terraform.
+type VersionInfo struct {
+	TerraformVersion   string            `json:"terraform_version"`
+	ProviderSelections map[string]string `json:"provider_selections"`
+}
+
+// Versions invokes terraform version -json and returns the terraform and selected provider versions.
+// Provider versions are only reported after terraform init has run in the working directory.
+func (c *Cmd) Versions() (*VersionInfo, error) {
+	stdout, _, err := c.run("version", "-json")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to invoke terraform version")
+	}
+
+	var versionInfo VersionInfo
+	err = json.Unmarshal(stdout, &versionInfo)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse terraform version")
+	}
+
+	return &versionInfo, nil
+}
+
+// RequiredVersion returns the required_version constraints declared in the terraform blocks of
+// the terraform files of the given directory, or an empty string if none is declared.
+func RequiredVersion(dir string) (string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return "", err
+	}
+	jsonFiles, err := filepath.Glob(filepath.Join(dir, "*.tf.json"))
+	if err != nil {
+		return "", err
+	}
+
+	parser := hclparse.NewParser()
+	var constraints []string
+	for _, file := range append(files, jsonFiles...) {
+		var parsed *hcl.File
+		var diags hcl.Diagnostics
+		if strings.HasSuffix(file, ".json") {
+			parsed, diags = parser.ParseJSONFile(file)
+		} else {
+			parsed, diags = parser.ParseHCLFile(file)
+		}
+		if diags.HasErrors() {
+			return "", errors.Wrapf(diags, "failed to parse terraform file %s", file)
+		}
+
+		content, _, diags := parsed.Body.PartialContent(terraformBlockSchema)
+		if diags.HasErrors() {
+			return "", errors.Wrapf(diags, "failed to parse terraform file %s", file)
+		}
+		for _, block := range content.Blocks {
+			blockContent, _, diags := block.Body.PartialContent(requiredVersionSchema)
+			if diags.HasErrors() {
+				return "", errors.Wrapf(diags, "failed to parse the terraform block of %s", file)
+			}
+			attribute, ok := blockContent.Attributes["required_version"]
+			if !ok {
+				continue
+			}
+
+			value, diags := attribute.Expr.Value(nil)
+			if diags.HasErrors() {
+				return "", errors.Wrapf(diags, "failed to evaluate required_version in %s", file)
+			}
+			if value.IsNull() || !value.IsKnown() || value.Type() != cty.String {
+				return "", errors.Errorf("required_version in %s must be a string", file)
+			}
+			constraints = append(constraints, value.AsString())
+		}
+	}
+
+	return strings.Join(constraints, ", "), nil
+}
+
+// CheckVersion checks that the terraform version satisfies the given constraint.
+func CheckVersion(terraformVersion, constraint string) error {
+	if constraint == "" {
+		return nil
+	}
+
+	current, err := version.NewVersion(terraformVersion)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse terraform version %s", terraformVersion)
+	}
+
+	constraints, err := version.NewConstraint(constraint)
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse terraform version constraint %s", constraint)
+	}
+
+	if !constraints.Check(current) {
+		return errors.Errorf("terraform version %s does not satisfy constraint %s", terraformVersion, constraint)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package terraform
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequiredVersion(t *testing.T) {
+	dir := t.TempDir()
+
+	t.Run("no constraint", func(t *testing.T) {
+		constraint, err := RequiredVersion(dir)
+		require.NoError(t, err)
+		assert.Equal(t, "", constraint)
+	})
+
+	t.Run("with constraint", func(t *testing.T) {
+		content := "terraform {\n  required_version = \">= 1.0.0\"\n  backend \"s3\" {}\n}\n"
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(content), 0600))
+
+		constraint, err := RequiredVersion(dir)
+		require.NoError(t, err)
+		assert.Equal(t, ">= 1.0.0", constraint)
+	})
+
+	t.Run("multi-line and commented constraints", func(t *testing.T) {
+		content := `# required_version = ">= 0.12.0"
+terraform {
+  /* required_version = "< 1.0.0" */
+  required_version = (
+    ">= 1.0.0, < 2.0.0"
+  )
+}
+
+resource "null_resource" "example" {
+  required_version = "not a terraform block"
+}
+`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte(content), 0600))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "versions.tf.json"), []byte(`{"terraform": {"required_version": "~> 1.1"}}`), 0600))
+
+		constraint, err := RequiredVersion(dir)
+		require.NoError(t, err)
+		assert.Equal(t, ">= 1.0.0, < 2.0.0, ~> 1.1", constraint)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.tf"), []byte("terraform {"), 0600))
+
+		_, err := RequiredVersion(dir)
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckVersion(t *testing.T) {
+	var versionTests = []struct {
+		name       string
+		version    string
+		constraint string
+		valid      bool
+	}{
+		{"empty constraint", "1.1.8", "", true},
+		{"satisfied", "1.1.8", ">= 1.0.0", true},
+		{"pessimistic satisfied", "1.1.8", "~> 1.1.0", true},
+		{"not satisfied", "0.15.5", ">= 1.0.0", false},
+		{"multiple constraints", "1.3.0", ">= 1.0.0, < 1.2.0", false},
+		{"invalid version", "not-a-version", ">= 1.0.0", false},
+	}
+
+	for _, tt := range versionTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckVersion(tt.version, tt.constraint)
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
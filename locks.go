@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pborman/uuid"
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	"github.com/mattermost/mattermost-apps/internal/tools/lock"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// defaultLockTTL is the TTL of the run and bundle leases if LockTTL is not set.
+	defaultLockTTL = 5 * time.Minute
+	// lockReleaseTimeout bounds the time spent releasing a lease.
+	lockReleaseTimeout = 30 * time.Second
+	// runLockName is the name of the lock held by the run command in the environment.
+	runLockName = "run"
+)
+
+// newLocker returns the DynamoDB locker of LockTable, or the file locker of LockDir if LockTable is
+// not set.
+func newLocker(session *session.Session) (lock.Locker, error) {
+	err := checkLocker()
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("LockTable") != "" {
+		return lock.NewDynamoDBLocker(os.Getenv("LockTable"), session), nil
+	}
+
+	return lock.NewFileLocker(os.Getenv("LockDir"))
+}
+
+// checkLocker returns an error if neither LockTable nor LockDir is set. File locks only exclude
+// the deployers sharing the lock directory, so they are only used if LockDir is set explicitly.
+func checkLocker() error {
+	if os.Getenv("LockTable") == "" && os.Getenv("LockDir") == "" {
+		return errors.New("LockTable must be set to lock deployments across deployer instances, or LockDir to use file locks shared by deployers on the same host")
+	}
+
+	return nil
+}
+
+// acquireLease claims the named lock of the environment for LockTTL, defaulting to
+// defaultLockTTL. It returns lock.ErrLocked if another deployer holds it.
+func acquireLease(ctx context.Context, session *session.Session, name string) (*lock.Lease, error) {
+	return acquireEnvironmentLease(ctx, session, os.Getenv("Environment"), name)
+}
+
+// acquireEnvironmentLease claims the named lock of the given environment, as acquireLease.
+func acquireEnvironmentLease(ctx context.Context, session *session.Session, environment, name string) (*lock.Lease, error) {
+	ttl := defaultLockTTL
+	if os.Getenv("LockTTL") != "" {
+		var err error
+		ttl, err = time.ParseDuration(os.Getenv("LockTTL"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid LockTTL")
+		}
+	}
+
+	locker, err := newLocker(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return lock.Acquire(ctx, locker, path.Join(environment, name), newLockOwner(), ttl)
+}
+
+// newLockOwner returns a unique owner for a lease, identifying the deployer holding it.
+func newLockOwner() string {
+	hostname, _ := os.Hostname()
+
+	return fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), uuid.New())
+}
+
+// releaseLease releases the lease, logging failures as the lock expires after its TTL anyway.
+func releaseLease(lease *lock.Lease, logger appsutils.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), lockReleaseTimeout)
+	defer cancel()
+
+	err := lease.Release(ctx)
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to release lock %s", lease.Name())
+	}
+}
+
+// leaseContext returns a context canceled if the lease is lost, so that the deployment holding it
+// stops before another deployer takes the lock over.
+func leaseContext(ctx context.Context, lease *lock.Lease, logger appsutils.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-lease.Lost():
+			logger.Errorf("Lost lock %s, stopping the deployment", lease.Name())
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// checkLease returns a lock error if the context is canceled, which happens once the lease held by
+// the deployment is lost. Stages check it before starting, so that no stage runs without the lock.
+func checkLease(ctx context.Context, stage string) error {
+	if ctx.Err() != nil {
+		return newStageError(categoryLock, stage, errors.Wrap(ctx.Err(), "deployment stopped as its lock was lost"))
+	}
+
+	return nil
+}
+
+// claimApp takes the lock of the app, so that it is rolled back or undeployed by a single deployer
+// at a time.
+func claimApp(ctx context.Context, appID string, session *session.Session) (*lock.Lease, error) {
+	lease, err := acquireLease(ctx, session, path.Join("apps", appID))
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, newStageError(categoryLock, "Claim app", errors.Wrapf(err, "app %s is claimed by another deployer", appID))
+	}
+	if err != nil {
+		return nil, newStageError(categoryLock, "Claim app", errors.Wrapf(err, "failed to claim app %s", appID))
+	}
+
+	return lease, nil
+}
+
+// claimBundle takes the lock of the bundle, so that it is deployed by a single deployer at a time.
+// If undeployedOnly is set, the bundle is checked again once claimed, and no lease is returned if
+// another deployer deployed it in the meantime.
+func claimBundle(ctx context.Context, bundle string, session *session.Session, undeployedOnly bool, logger appsutils.Logger) (*lock.Lease, error) {
+	lease, err := acquireLease(ctx, session, path.Join("bundles", bundle))
+	if errors.Is(err, lock.ErrLocked) {
+		return nil, newStageError(categoryLock, "Claim bundle", errors.Wrapf(err, "bundle %s is claimed by another deployer", bundle))
+	}
+	if err != nil {
+		return nil, newStageError(categoryLock, "Claim bundle", errors.Wrapf(err, "failed to claim bundle %s", bundle))
+	}
+	if !undeployedOnly {
+		return lease, nil
+	}
+
+	deployed, err := awsTools.IsBundleDeployed(os.Getenv("AppsBundleBucketName"), bundle, session)
+	if err != nil || deployed {
+		releaseLease(lease, logger)
+	}
+	if err != nil {
+		return nil, newStageError(categoryStorage, "Check bundle deployment", errors.Wrapf(err, "failed to check if bundle %s is deployed", bundle))
+	}
+	if deployed {
+		return nil, nil
+	}
+
+	return lease, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/mattermost/mattermost-apps/internal/tools/lock"
+)
+
+func TestAcquireLease(t *testing.T) {
+	t.Setenv("Environment", "test")
+	t.Setenv("LockDir", t.TempDir())
+
+	lease, err := acquireLease(context.Background(), nil, runLockName)
+	require.NoError(t, err)
+	assert.Equal(t, "test/run", lease.Name())
+
+	_, err = acquireLease(context.Background(), nil, runLockName)
+	assert.ErrorIs(t, err, lock.ErrLocked)
+
+	// Bundles are claimed separately from the run.
+	bundleLease, err := claimBundle(context.Background(), "hello-world.zip", nil, false, nil)
+	require.NoError(t, err)
+	_, err = claimBundle(context.Background(), "hello-world.zip", nil, false, nil)
+	assert.ErrorIs(t, err, lock.ErrLocked)
+	assert.Equal(t, categoryLock, getDeploymentError(err).Category)
+
+	// Apps are claimed separately from their bundles.
+	appLease, err := claimApp(context.Background(), "hello-world", nil)
+	require.NoError(t, err)
+	_, err = claimApp(context.Background(), "hello-world", nil)
+	assert.ErrorIs(t, err, lock.ErrLocked)
+	assert.Equal(t, categoryLock, getDeploymentError(err).Category)
+
+	require.NoError(t, appLease.Release(context.Background()))
+	require.NoError(t, bundleLease.Release(context.Background()))
+	require.NoError(t, lease.Release(context.Background()))
+
+	t.Run("no lock configured", func(t *testing.T) {
+		t.Setenv("LockDir", "")
+		t.Setenv("LockTable", "")
+		assert.Error(t, checkLocker())
+		_, err := acquireLease(context.Background(), nil, runLockName)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid TTL", func(t *testing.T) {
+		t.Setenv("LockTTL", "soon")
+		_, err := acquireLease(context.Background(), nil, runLockName)
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckLease(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	require.NoError(t, checkLease(ctx, "Deploy lambda"))
+
+	cancel()
+	err := checkLease(ctx, "Deploy lambda")
+	require.Error(t, err)
+	assert.Equal(t, categoryLock, getDeploymentError(err).Category)
+	assert.Equal(t, "Deploy lambda", getDeploymentError(err).Stage)
+}
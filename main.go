@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap/zapcore"
 
 	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
 	exechelper "github.com/mattermost/mattermost-apps/internal/tools/exechelper"
+	"github.com/mattermost/mattermost-apps/internal/tools/lock"
 	terraform "github.com/mattermost/mattermost-apps/internal/tools/terraform"
 	model "github.com/mattermost/mattermost-apps/model"
 	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
@@ -24,179 +29,759 @@ const (
 	manifestFileName = "manifest.json"
 )
 
+const (
+	// commandRun deploys every bundle of the bundle bucket which was not deployed yet, and exits.
+	commandRun = "run"
+	// commandEvents deploys bundles as their upload events are received from EventQueueURL.
+	commandEvents = "events"
+	// commandServe serves the deployer HTTP API.
+	commandServe = "serve"
+	// commandGC removes the static assets and bundles which are not used anymore, and exits.
+	commandGC = "gc"
+)
+
 func main() {
 	logger := appsutils.MustMakeCommandLogger(zapcore.InfoLevel)
 
-	err := checkEnvVariables()
+	command := commandRun
+	if len(os.Args) > 1 {
+		command = os.Args[1]
+	}
+
+	switch command {
+	case commandRun:
+		run(logger)
+	case commandEvents:
+		runEvents(logger)
+	case commandServe:
+		runServer(logger)
+	case commandGC:
+		runGC(logger)
+	default:
+		logger.Errorf("Unknown command %s, expected %s, %s, %s or %s", command, commandRun, commandEvents, commandServe, commandGC)
+		os.Exit(exitCodeSetupError)
+	}
+}
+
+// run deploys every bundle which was not deployed yet, and exits with the outcome of the run.
+func run(logger appsutils.Logger) {
+	report := newRunReport()
+
+	err := initTracing(context.Background())
 	if err != nil {
-		logger.WithError(err).Errorf("Environment variables were not set")
-		err = sendMattermostErrorNotification(err, "Mattermost apps deployment failed.")
+		logger.WithError(err).Warnf("Failed to initialize tracing, traces will not be exported")
+	}
+	ctx, runSpan := startSpan(context.Background(), "deployer run", attribute.String("environment", os.Getenv("Environment")))
+
+	session := setup(report, runSpan, logger)
+
+	runLease, err := acquireLease(ctx, session, runLockName)
+	if errors.Is(err, lock.ErrLocked) {
+		logger.Infof("Another deployer run is in progress in %s", os.Getenv("Environment"))
+		endSpan(runSpan, nil)
+		shutdownTracing(logger)
+		report.finish(exitCodeSuccess, nil)
+		err = report.write()
 		if err != nil {
-			logger.WithError(err).Errorf("Failed to send Mattermost error notification")
+			logger.WithError(err).Errorf("Failed to write run report")
 		}
-		os.Exit(1)
+		os.Exit(exitCodeSuccess)
+	}
+	if err != nil {
+		err = newStageError(categoryLock, "Acquire run lock", err)
+		logger.WithError(err).Errorf("Failed to acquire the run lock")
+		exitWithSetupError(err, "Mattermost apps deployer failed to acquire the run lock.", report, runSpan, logger)
 	}
 
-	session, err := awsTools.GetAssumeRoleSession(os.Getenv("AppsAssumeRole"))
+	summary := newRunSummary()
+
+	_, span := startSpan(ctx, "discover bundles")
+	bundles, skipped, err := awsTools.GetBundles(os.Getenv("AppsBundleBucketName"), session, logger)
 	if err != nil {
-		logger.WithError(err).Errorf("Failed to get assumed role session")
-		err = sendMattermostErrorNotification(err, "Mattermost apps deployment failed.")
+		err = newStageError(categoryStorage, "List bundles", err)
+		endSpan(span, err)
+		logger.WithError(err).Errorf("Failed to get app bundles")
+		exitWithSetupError(err, "Mattermost apps deployer failed to list the app bundles.", report, runSpan, logger)
+	}
+	span.SetAttributes(attribute.Int("bundles", len(bundles)), attribute.Int("skipped", len(skipped)))
+	endSpan(span, nil)
+	summary.addSkipped(skipped, skipReasonDeployed)
+	report.addSkipped(skipped, skipReasonDeployed)
+	bundlesTotal.WithLabelValues(bundleStatusDiscovered).Add(float64(len(bundles) + len(skipped)))
+
+bundles:
+	for _, bundle := range bundles {
+		select {
+		case <-runLease.Lost():
+			logger.Errorf("Lost the run lock, leaving the remaining bundles to the next run")
+			break bundles
+		default:
+		}
+
+		// Failures are recorded in the summary and report, and do not stop the run.
+		deployBundle(ctx, bundle, session, report.DryRun, true, summary, report, logger)
+	}
+	releaseLease(runLease, logger)
+
+	summary.Duration = time.Since(summary.StartedAt)
+	logger.Infof("Deployed %d, planned %d, skipped %d and failed %d bundles", len(summary.Deployed), len(summary.Planned), len(summary.Skipped), len(summary.Failed))
+	recordSummaryMetrics(summary)
+	if !summary.isEmpty() {
+		err = sendSummaryNotification(summary)
 		if err != nil {
-			logger.WithError(err).Errorf("Failed to send Mattermost error notification")
+			logger.WithError(err).Errorf("Failed to send Mattermost summary notification")
 		}
-		os.Exit(1)
 	}
 
-	bundles, err := awsTools.GetBundles(os.Getenv("AppsBundleBucketName"), session, logger)
+	err = exportMetrics()
 	if err != nil {
-		logger.WithError(err).Errorf("Failed to get app bundles")
-		err = sendMattermostErrorNotification(err, "Mattermost apps deployment failed.")
+		logger.WithError(err).Errorf("Failed to export metrics")
+	}
+
+	runSpan.SetAttributes(
+		attribute.Int("deployed", len(summary.Deployed)),
+		attribute.Int("planned", len(summary.Planned)),
+		attribute.Int("failed", len(summary.Failed)),
+	)
+	endSpan(runSpan, nil)
+	shutdownTracing(logger)
+
+	report.finish(summary.exitCode(), nil)
+	err = report.write()
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to write run report")
+	}
+	os.Exit(report.ExitCode)
+}
+
+// setup checks the deployer configuration, including the notification sinks, the deployment
+// locks and the Terraform version, and assumes the deployment role. It exits with
+// exitCodeSetupError if any of them fails.
+func setup(report *runReport, runSpan trace.Span, logger appsutils.Logger) *session.Session {
+	checkConfiguration(deployEnvVariables, report, runSpan, logger)
+
+	err := newStageError(categoryConfiguration, "Check Terraform version", checkTerraformVersion(logger))
+	if err != nil {
+		logger.WithError(err).Errorf("Terraform version check failed")
+		exitWithSetupError(err, "Mattermost apps deployer Terraform version check failed.", report, runSpan, logger)
+	}
+
+	return assumeDeploymentRole(report, runSpan, logger)
+}
+
+// checkConfiguration checks that the given environment variables are set, and that the
+// notification sinks and the deployment locks are configured. It exits with exitCodeSetupError
+// if any of them is not.
+func checkConfiguration(envVariables []string, report *runReport, runSpan trace.Span, logger appsutils.Logger) {
+	err := newStageError(categoryConfiguration, "Check environment variables", checkEnvVariables(envVariables))
+	if err != nil {
+		logger.WithError(err).Errorf("Environment variables were not set")
+		exitWithSetupError(err, "Mattermost apps deployer is missing required environment variables.", report, runSpan, logger)
+	}
+
+	err = newStageError(categoryConfiguration, "Check notification sinks", checkNotifiers())
+	if err != nil {
+		logger.WithError(err).Errorf("Notification sinks are not configured")
+		exitWithSetupError(err, "Mattermost apps deployer notification sinks are not configured.", report, runSpan, logger)
+	}
+
+	err = newStageError(categoryConfiguration, "Check lock configuration", checkLocker())
+	if err != nil {
+		logger.WithError(err).Errorf("Deployment locks are not configured")
+		exitWithSetupError(err, "Mattermost apps deployer deployment locks are not configured.", report, runSpan, logger)
+	}
+}
+
+// assumeDeploymentRole returns a session of the deployment role. It exits with
+// exitCodeSetupError if the role cannot be assumed.
+func assumeDeploymentRole(report *runReport, runSpan trace.Span, logger appsutils.Logger) *session.Session {
+	session, err := awsTools.GetAssumeRoleSession(os.Getenv("AppsAssumeRole"))
+	if err != nil {
+		err = newStageError(categoryCredentials, "Assume deployment role", err)
+		logger.WithError(err).Errorf("Failed to get assumed role session")
+		exitWithSetupError(err, "Mattermost apps deployer failed to assume the deployment role.", report, runSpan, logger)
+	}
+
+	return session
+}
+
+// deployBundle deploys, or plans in dry run mode, the bundle, records its outcome in the run
+// summary and report, and sends the per-bundle notification. The bundle is claimed first, and
+// skipped if another deployer holds its lock, or if undeployedOnly is set and it was deployed in
+// the meantime. It returns an error if the bundle is claimed by another deployer, so that callers
+// can retry it.
+func deployBundle(ctx context.Context, bundle string, session *session.Session, dryRun, undeployedOnly bool, summary *runSummary, report *runReport, logger appsutils.Logger) error {
+	lease, err := claimBundle(ctx, bundle, session, undeployedOnly, logger)
+	if (err == nil && lease == nil) || errors.Is(err, lock.ErrLocked) {
+		reason := skipReasonDeployed
 		if err != nil {
-			logger.WithError(err).Errorf("Failed to send Mattermost error notification")
+			reason = skipReasonClaimed
 		}
-		os.Exit(1)
+		logger.Infof("Skipping bundle %s, which is %s", bundle, strings.ToLower(skipReasonLabels[reason]))
+		summary.addSkipped([]string{bundle}, reason)
+		report.addSkipped([]string{bundle}, reason)
+		return err
 	}
 
-	for _, bundle := range bundles {
-		var deployData *apps.DeployData
-		deployData, err = handleBundleDeployment(bundle, session, logger)
-		if err != nil {
-			logger.WithError(err).Errorf("Failed to deploy bundle")
-			err = sendMattermostErrorNotification(err, "Mattermost apps deployment failed.")
-			if err != nil {
-				logger.WithError(err).Errorf("Failed to send Mattermost error notification")
+	start := time.Now()
+	bundleCtx, bundleSpan := startSpan(ctx, "deploy bundle", attribute.String("bundle", bundle))
+	progress := newDeploymentProgress(bundle, logger)
+	var deployment *model.Deployment
+	if err == nil {
+		leaseCtx, cancel := leaseContext(bundleCtx, lease, logger)
+		deployment, err = handleBundleDeployment(leaseCtx, bundle, session, dryRun, progress, logger)
+		cancel()
+		releaseLease(lease, logger)
+	}
+	err = classifyBundleError(err, bundle)
+	endSpan(bundleSpan, err)
+	progress.finish(err)
+	if deployment != nil {
+		deployment.Duration = time.Since(start)
+	}
+	summary.addResult(bundle, deployment, time.Since(start), err)
+	report.addResult(bundle, deployment, progress, time.Since(start), err)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to deploy bundle")
+		if perBundleNotifications() {
+			notifyErr := sendMattermostErrorNotification(err, fmt.Sprintf("Mattermost apps deployment failed for bundle %s.", bundle))
+			if notifyErr != nil {
+				logger.WithError(notifyErr).Errorf("Failed to send Mattermost error notification")
 			}
-			continue
 		}
+		return err
+	}
 
-		err = sendAppDeploymentNotification(deployData, bundle)
+	if perBundleNotifications() {
+		err = sendAppDeploymentNotification(deployment)
 		if err != nil {
 			logger.WithError(err).Errorf("Failed to send Mattermost error notification")
 		}
 	}
+
+	return nil
 }
 
-func checkEnvVariables() error {
-	var envVariables = []string{
-		"AppsBundleBucketName",
-		"TempDir",
-		"TerraformTemplateDir",
-		"TerraformStateBucket",
-		"AppsAssumeRole",
-		"StaticBucket",
-		"Environment",
-		"TerraformApply",
-		"MattermostNotificationsHook",
-		"MattermostAlertsHook",
-		"PrivateSubnetIDs",
+// exitWithSetupError reports the error which prevented the deployer from deploying any bundle,
+// and exits with exitCodeSetupError.
+func exitWithSetupError(setupErr error, message string, report *runReport, runSpan trace.Span, logger appsutils.Logger) {
+	endSpan(runSpan, setupErr)
+
+	err := sendMattermostErrorNotification(setupErr, message)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to send Mattermost error notification")
 	}
 
+	shutdownTracing(logger)
+
+	report.finish(exitCodeSetupError, setupErr)
+	err = report.write()
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to write run report")
+	}
+	os.Exit(exitCodeSetupError)
+}
+
+// deployEnvVariables are the environment variables required to deploy bundles.
+var deployEnvVariables = []string{
+	"AppsBundleBucketName",
+	"TempDir",
+	"TerraformTemplateDir",
+	"TerraformStateBucket",
+	"AppsAssumeRole",
+	"StaticBucket",
+	"Environment",
+	"TerraformApply",
+	"PrivateSubnetIDs",
+}
+
+// checkEnvVariables returns an error listing the given environment variables which are not set.
+func checkEnvVariables(envVariables []string) error {
+	var missing []string
 	for _, envVar := range envVariables {
 		if os.Getenv(envVar) == "" {
-			return errors.Errorf("Environment variable %s was not set", envVar)
+			missing = append(missing, envVar)
 		}
 	}
+	if len(missing) > 0 {
+		return errors.Errorf("Environment variables %s were not set", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// checkTerraformVersion checks that the terraform binary on the PATH satisfies both the
+// configured TerraformVersionConstraint and the required_version of the template directory.
+func checkTerraformVersion(logger appsutils.Logger) error {
+	tf, err := terraform.New(os.Getenv("TerraformTemplateDir"), os.Getenv("TerraformStateBucket"), logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to initiate Terraform")
+	}
+
+	versionInfo, err := tf.Versions()
+	if err != nil {
+		return errors.Wrap(err, "failed to get Terraform version")
+	}
+
+	err = terraform.CheckVersion(versionInfo.TerraformVersion, os.Getenv("TerraformVersionConstraint"))
+	if err != nil {
+		return errors.Wrap(err, "Terraform binary does not satisfy the configured version constraint")
+	}
+
+	requiredVersion, err := terraform.RequiredVersion(os.Getenv("TerraformTemplateDir"))
+	if err != nil {
+		return errors.Wrap(err, "failed to get the Terraform template required version")
+	}
+
+	err = terraform.CheckVersion(versionInfo.TerraformVersion, requiredVersion)
+	if err != nil {
+		return errors.Wrap(err, "Terraform binary is not compatible with the Terraform template")
+	}
+
+	logger.Infof("Using Terraform version %s", versionInfo.TerraformVersion)
 
 	return nil
 }
 
-func handleBundleDeployment(bundle string, session *session.Session, logger appsutils.Logger) (*apps.DeployData, error) {
+// handleBundleDeployment runs the deployment stages of the bundle. The local files of the bundle
+// are removed before the stages, in case a previous attempt failed to remove them, and after the
+// stages whether they succeeded or not.
+func handleBundleDeployment(ctx context.Context, bundle string, session *session.Session, dryRun bool, progress *deploymentProgress, logger appsutils.Logger) (*model.Deployment, error) {
 	bundleName := strings.TrimSuffix(bundle, ".zip")
 
 	logger = logger.With("bundle", bundleName)
 
+	localFiles := []string{path.Join(os.Getenv("TempDir"), bundle), path.Join(os.Getenv("TempDir"), bundleName)}
+	err := exechelper.RemoveLocalFiles(localFiles, logger)
+	if err != nil {
+		return nil, newStageError(categoryStorage, "Remove local files", errors.Wrap(err, "failed to delete local files of a previous attempt"))
+	}
+
+	checkpoint, err := loadCheckpoint(bundle, session, dryRun, logger)
+	if err != nil {
+		return nil, newStageError(categoryStorage, "Load checkpoint", err)
+	}
+
+	transaction := newDeploymentTransaction(dryRun)
+	deployment, err := runDeploymentStages(ctx, bundle, session, dryRun, checkpoint, transaction, progress, logger)
+	if err != nil && transaction.pending() && ctx.Err() != nil {
+		// The deployment lost its lock, and reverting it could undo the changes of the new holder.
+		logger.WithError(err).Warnf("Not reverting the changes of the deployment, which lost its lock")
+	} else if err != nil && transaction.pending() {
+		logger.WithError(err).Warnf("Reverting the changes of the failed transactional deployment")
+		progress.stage("Revert deployment")
+		revertCtx, span := startSpan(ctx, "revert deployment")
+		revertErr := transaction.compensate(revertCtx, deployment, bundleName, logger)
+		endSpan(span, revertErr)
+		if revertErr != nil {
+			logger.WithError(revertErr).Errorf("Failed to revert the changes of the failed deployment")
+			err = errors.Wrapf(err, "failed to revert the changes of the deployment (%s)", revertErr)
+		}
+
+		// The next attempt starts over, as the completed stages were reverted.
+		clearErr := checkpoint.clear()
+		if clearErr != nil {
+			logger.WithError(clearErr).Warnf("Failed to remove the checkpoint of the reverted deployment")
+		}
+	}
+
+	logger.Infof("Removing local files for bundle %s", bundleName)
+	_, span := startSpan(ctx, "remove local files")
+	cleanupErr := exechelper.RemoveLocalFiles(localFiles, logger)
+	endSpan(span, cleanupErr)
+	if cleanupErr != nil {
+		if err != nil {
+			logger.WithError(cleanupErr).Warnf("Failed to delete local files")
+			return deployment, err
+		}
+		return deployment, newStageError(categoryStorage, "Remove local files", errors.Wrap(cleanupErr, "failed to delete local files"))
+	}
+
+	return deployment, err
+}
+
+// runDeploymentStages downloads the bundle, uploads its assets, deploys its lambda functions and
+// tags it as deployed. The stages completed by a previous attempt which failed are skipped, and
+// the checkpoint is removed once the bundle is deployed. The deployment stops before the next
+// stage, and Terraform is killed, once ctx is canceled as the bundle lock was lost. In dry run
+// mode the bundle is only downloaded and its lambda changes planned: nothing is uploaded,
+// published, recorded or tagged.
+func runDeploymentStages(ctx context.Context, bundle string, session *session.Session, dryRun bool, checkpoint *bundleCheckpoint, transaction *deploymentTransaction, progress *deploymentProgress, logger appsutils.Logger) (*model.Deployment, error) {
+	bundleName := strings.TrimSuffix(bundle, ".zip")
+
+	err := checkLease(ctx, "Download bundle")
+	if err != nil {
+		return nil, err
+	}
+
 	logger.Infof("Downloading bundle from s3")
-	err := awsTools.DownloadS3Object(os.Getenv("AppsBundleBucketName"), bundle, os.Getenv("TempDir"), session)
+	progress.stage("Download bundle")
+	_, span := startSpan(ctx, "download bundle")
+	start := time.Now()
+	err = awsTools.DownloadS3Object(os.Getenv("AppsBundleBucketName"), bundle, os.Getenv("TempDir"), session)
+	observeStage(stageDownload, start)
+	endSpan(span, err)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get s3 object")
+		return nil, newStageError(categoryStorage, "Download bundle", errors.Wrap(err, "failed to get s3 object"))
 	}
 
 	logger.Infof("Unzipping bundle")
+	progress.stage("Unzip bundle")
+	_, span = startSpan(ctx, "unzip bundle")
+	start = time.Now()
 	err = exechelper.UnzipBundle(os.Getenv("TempDir"), bundle)
+	observeStage(stageUnzip, start)
+	endSpan(span, err)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to unzip the bundle")
+		return nil, newStageError(categoryBundleInvalid, "Unzip bundle", errors.Wrap(err, "failed to unzip the bundle"))
 	}
 
 	logger.Infof("Getting bundle details")
+	_, span = startSpan(ctx, "get bundle details")
 	provisionData, err := apps.GetDeployDataFromFile(path.Join(os.Getenv("TempDir"), bundle), logger)
+	endSpan(span, err)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to get bundle details for bundle")
+		return nil, newStageError(categoryBundleInvalid, "Get bundle details", errors.Wrap(err, "failed to get bundle details for bundle"))
 	}
 
-	logger.Infof("Uploading bundle assets in %s", os.Getenv("StaticBucket"))
-	err = awsTools.UploadStaticFiles(provisionData.StaticFiles, bundleName, logger)
-	if err != nil {
-		return provisionData, errors.Wrap(err, "failed to upload bundle assets")
+	deployment := &model.Deployment{
+		Bundle:     bundle,
+		DeployData: provisionData,
+		Manifest:   provisionData.Manifest,
+		DryRun:     dryRun,
 	}
 
-	logger.Infof("Uploading bundle manifest file in %s", os.Getenv("StaticBucket"))
-	err = awsTools.UploadManifestFile(provisionData.ManifestKey, manifestFileName, bundleName, logger)
+	err = writeAliasedManifest(provisionData.Manifest, bundleName)
 	if err != nil {
-		return provisionData, errors.Wrap(err, "failed to upload bundle manifest file")
+		return deployment, newStageError(categoryBundleInvalid, "Get bundle details", errors.Wrap(err, "failed to route the manifest functions through the lambda alias"))
+	}
+
+	if dryRun {
+		logger.Infof("Dry run, skipping the upload of %d static assets", len(provisionData.StaticFiles))
+	} else if checkpoint.completed(checkpointUploadAssets) {
+		logger.Infof("Bundle assets were uploaded by a previous attempt")
+		deployment.ResumedStages = append(deployment.ResumedStages, checkpointUploadAssets)
+		transaction.stagedAssets = checkpoint.checkpoint.StagedAssets
+	} else {
+		err = checkLease(ctx, "Upload static assets")
+		if err != nil {
+			return deployment, err
+		}
+
+		err = transaction.stage(provisionData.StaticFiles)
+		if err != nil {
+			return deployment, newStageError(categoryStorage, "Stage static assets", err)
+		}
+
+		logger.Infof("Uploading bundle assets in %s", os.Getenv("StaticBucket"))
+		progress.stage("Upload static assets")
+		_, span = startSpan(ctx, "upload static assets")
+		start = time.Now()
+		deployment.StaticAssets, err = awsTools.UploadStaticFiles(provisionData.StaticFiles, bundleName, logger)
+		observeStage(stageUpload, start)
+		if deployment.StaticAssets != nil {
+			uploadedBytesTotal.Add(float64(deployment.StaticAssets.UploadedBytes))
+			skippedBytesTotal.Add(float64(deployment.StaticAssets.SkippedBytes))
+			span.SetAttributes(
+				attribute.Int("uploaded_files", deployment.StaticAssets.UploadedFiles),
+				attribute.Int64("uploaded_bytes", deployment.StaticAssets.UploadedBytes),
+				attribute.Int("skipped_files", deployment.StaticAssets.SkippedFiles),
+				attribute.Int64("skipped_bytes", deployment.StaticAssets.SkippedBytes),
+			)
+		}
+		endSpan(span, err)
+		if err != nil {
+			return deployment, newStageError(categoryStorage, "Upload static assets", errors.Wrap(err, "failed to upload bundle assets"))
+		}
+		checkpoint.checkpoint.StagedAssets = transaction.stagedAssets
+		err = checkpoint.complete(checkpointUploadAssets, nil)
+		if err != nil {
+			return deployment, err
+		}
 	}
 
 	logger.Infof("Deploying lambdas")
-	err = deployLambdas(logger, provisionData.LambdaFunctions, bundle, bundleName)
+	err = deployLambdas(ctx, logger, deployment, progress, checkpoint, provisionData.LambdaFunctions, bundleName)
 	if err != nil {
-		return provisionData, errors.Wrap(err, "failed to deploy lambda functions for bundle")
+		return deployment, errors.Wrap(err, "failed to deploy lambda functions for bundle")
+	}
+
+	// The manifest is published last, so that the new app version goes live once its static assets
+	// and lambda functions are in place.
+	if dryRun {
+		logger.Infof("Dry run, skipping the upload of the bundle manifest file")
+	} else {
+		err = checkLease(ctx, "Upload manifest")
+		if err != nil {
+			return deployment, err
+		}
+
+		err = uploadManifest(ctx, deployment, checkpoint, progress, bundleName, logger)
+		if err != nil {
+			return deployment, err
+		}
+		transaction.commit()
+	}
+
+	if checkpoint.completed(checkpointRemoveOrphans) {
+		logger.Infof("Orphaned lambdas were removed by a previous attempt")
+		deployment.ResumedStages = append(deployment.ResumedStages, checkpointRemoveOrphans)
+	} else {
+		err = checkLease(ctx, "Remove orphaned lambdas")
+		if err != nil {
+			return deployment, err
+		}
+
+		logger.Infof("Removing orphaned lambdas of previous app versions")
+		progress.stage("Remove orphaned lambdas")
+		orphansCtx, span := startSpan(ctx, "remove orphaned lambdas")
+		err = removeOrphanedLambdas(orphansCtx, deployment, bundleName, logger)
+		endSpan(span, err)
+		if err != nil {
+			return deployment, newStageError(categoryTerraform, "Remove orphaned lambdas", errors.Wrap(err, "failed to remove orphaned lambda functions"))
+		}
+		err = checkpoint.complete(checkpointRemoveOrphans, nil)
+		if err != nil {
+			return deployment, err
+		}
+	}
+
+	if !dryRun {
+		logger.Infof("Removing the static assets and lambda functions of retired app versions")
+		pruneCtx, span := startSpan(ctx, "prune retired versions")
+		err = pruneRetiredVersions(pruneCtx, string(provisionData.Manifest.AppID), logger)
+		endSpan(span, err)
+		if err != nil {
+			// The deployment is complete, and the retired versions are pruned by the next one.
+			logger.WithError(err).Warnf("Failed to remove the static assets and lambda functions of retired app versions")
+		}
+	}
+
+	if !dryRun {
+		err = checkLease(ctx, "Tag bundle as deployed")
+		if err != nil {
+			return deployment, err
+		}
+
+		logger.Infof("Tagging bundle object %s as deployed", bundleName)
+		progress.stage("Tag bundle as deployed")
+		_, span = startSpan(ctx, "tag bundle as deployed")
+		err = awsTools.PutDeployedObjectTag(os.Getenv("AppsBundleBucketName"), bundle, string(provisionData.Manifest.AppID), string(provisionData.Manifest.Version), session)
+		endSpan(span, err)
+		if err != nil {
+			return deployment, newStageError(categoryStorage, "Tag bundle as deployed", errors.Wrap(err, "failed to tag bundle object as deployed"))
+		}
 	}
 
-	logger.Infof("Tagging bundle object %s as deployed", bundleName)
-	err = awsTools.PutDeployedObjectTag(os.Getenv("AppsBundleBucketName"), bundle, session)
+	err = checkpoint.clear()
 	if err != nil {
-		return provisionData, errors.Wrap(err, "failed to tag bundle object as deployed")
+		return deployment, err
 	}
 
-	logger.Infof("Removing local files for bundle %s", bundleName)
-	err = exechelper.RemoveLocalFiles([]string{path.Join(os.Getenv("TempDir"), bundle), path.Join(os.Getenv("TempDir"), bundleName)}, logger)
+	return deployment, nil
+}
+
+// uploadManifest publishes the manifest of the app version, unless a previous attempt did.
+func uploadManifest(ctx context.Context, deployment *model.Deployment, checkpoint *bundleCheckpoint, progress *deploymentProgress, bundleName string, logger appsutils.Logger) error {
+	if checkpoint.completed(checkpointUploadManifest) {
+		logger.Infof("Bundle manifest file was uploaded by a previous attempt")
+		deployment.ResumedStages = append(deployment.ResumedStages, checkpointUploadManifest)
+		return nil
+	}
+
+	logger.Infof("Uploading bundle manifest file in %s", os.Getenv("StaticBucket"))
+	progress.stage("Upload manifest")
+	_, span := startSpan(ctx, "upload manifest")
+	start := time.Now()
+	uploaded, err := awsTools.UploadManifestFile(deployment.DeployData.ManifestKey, manifestFileName, bundleName, logger)
+	observeStage(stageUpload, start)
+	uploadedBytesTotal.Add(float64(uploaded))
+	endSpan(span, err)
 	if err != nil {
-		return provisionData, errors.Wrap(err, "failed to delete local files")
+		return newStageError(categoryStorage, "Upload manifest", errors.Wrap(err, "failed to upload bundle manifest file"))
 	}
 
-	return provisionData, nil
+	return checkpoint.complete(checkpointUploadManifest, nil)
 }
 
-func deployLambdas(logger utils.Logger, lambdaFunctions map[string]apps.FunctionData, bundle, bundleName string) error {
-	for zipFile, lambda := range lambdaFunctions {
-		logger := logger.With("lambda_name", lambda.Name)
+func deployLambdas(ctx context.Context, logger utils.Logger, deployment *model.Deployment, progress *deploymentProgress, checkpoint *bundleCheckpoint, lambdaFunctions map[string]apps.FunctionData, bundleName string) error {
+	var smokeTestPayload []byte
+	if smokeTestEnabled() {
+		var err error
+		smokeTestPayload, err = getSmokeTestPayload(bundleName)
+		if err != nil {
+			return newStageError(categoryBundleInvalid, "Get smoke test payload", errors.Wrap(err, "failed to get smoke test payload"))
+		}
+	}
 
-		function := model.Function{
-			Name:        lambda.Name,
-			Environment: os.Getenv("Environment"),
-			Runtime:     lambda.Runtime,
-			Handler:     lambda.Handler,
-			ZipFile:     fmt.Sprintf("%s.zip", zipFile),
-			BundleName:  bundleName,
+	for manifestName, lambda := range lambdaFunctions {
+		stage := checkpointLambdaPrefix + lambda.Name
+		if checkpoint.completed(stage) {
+			logger.With("lambda_name", lambda.Name).Infof("Lambda function was deployed by a previous attempt")
+			deployment.ResumedStages = append(deployment.ResumedStages, stage)
+			if result := checkpoint.lambda(lambda.Name); result != nil {
+				deployment.Lambdas = append(deployment.Lambdas, *result)
+			}
+			continue
 		}
 
-		tf, err := terraform.New(os.Getenv("TerraformTemplateDir"), os.Getenv("TerraformStateBucket"), logger)
+		err := checkLease(ctx, "Deploy lambda")
 		if err != nil {
-			return errors.Wrap(err, "failed to initiate Terraform")
+			return err
 		}
 
-		err = tf.Init(lambda.Name)
+		progress.stage("Deploy lambda `%s`", lambda.Name)
+
+		lambdaCtx, span := startSpan(ctx, "deploy lambda", attribute.String("lambda", lambda.Name))
+		err = deployLambda(lambdaCtx, logger.With("lambda_name", lambda.Name), deployment, newFunction(manifestName, lambda, bundleName), smokeTestPayload)
+		endSpan(span, err)
 		if err != nil {
-			return errors.Wrap(err, "failed to run Terraform init")
+			return err
 		}
 
-		if os.Getenv("TerraformApply") == "true" {
-			logger.Infof("applying Terraform template")
-			err = tf.Apply(function)
-			if err != nil {
-				return errors.Wrap(err, "failed to run Terraform apply")
-			}
-			logger.Infof("Successfully deployed lambda function")
-			continue
+		var result *model.LambdaResult
+		if !deployment.DryRun {
+			result = &deployment.Lambdas[len(deployment.Lambdas)-1]
 		}
-		err = tf.Plan(function)
+		err = checkpoint.complete(stage, result)
 		if err != nil {
-			return errors.Wrap(err, "failed to run Terraform plan")
+			return err
 		}
-		logger.Infof("Successfully ran Terraform plan")
-
 	}
 
 	logger.Infof("Successfully deployed all lambda functions")
 
 	return nil
 }
+
+// deployLambda applies the Terraform template of the lambda function and releases the new version,
+// or plans the changes in dry run mode.
+func deployLambda(ctx context.Context, logger utils.Logger, deployment *model.Deployment, function model.Function, smokeTestPayload []byte) error {
+	initCtx, span := startSpan(ctx, "terraform init")
+	start := time.Now()
+	tf, err := terraform.New(os.Getenv("TerraformTemplateDir"), os.Getenv("TerraformStateBucket"), logger)
+	if err != nil {
+		endSpan(span, err)
+		return newLambdaStageError(categoryTerraform, "Terraform init", function.Name, errors.Wrap(err, "failed to initiate Terraform"))
+	}
+
+	err = tf.WithContext(initCtx).Init(function.Name)
+	observeStage(stageTerraformInit, start)
+	if err != nil {
+		endSpan(span, err)
+		return newLambdaStageError(categoryTerraform, "Terraform init", function.Name, errors.Wrap(err, "failed to run Terraform init"))
+	}
+
+	versionInfo, err := tf.WithContext(initCtx).Versions()
+	endSpan(span, err)
+	if err != nil {
+		return newLambdaStageError(categoryTerraform, "Terraform init", function.Name, errors.Wrap(err, "failed to get Terraform and provider versions"))
+	}
+	logger.Infof("Using Terraform version %s with providers %v", versionInfo.TerraformVersion, versionInfo.ProviderSelections)
+	deployment.TerraformVersion = versionInfo.TerraformVersion
+	deployment.ProviderVersions = versionInfo.ProviderSelections
+
+	if deployment.DryRun {
+		planCtx, span := startSpan(ctx, "terraform plan")
+		start = time.Now()
+		plan, err := tf.WithContext(planCtx).Plan(function)
+		observeStage(stageTerraformPlan, start)
+		endSpan(span, err)
+		if err != nil {
+			return newLambdaStageError(categoryTerraform, "Terraform plan", function.Name, errors.Wrap(err, "failed to run Terraform plan"))
+		}
+		deployment.Plans = append(deployment.Plans, newPlanResult(function.Name, plan))
+		logger.Infof("Successfully ran Terraform plan: %s", plan)
+
+		return nil
+	}
+
+	logger.Infof("applying Terraform template")
+	applyCtx, span := startSpan(ctx, "terraform apply")
+	start = time.Now()
+	err = tf.WithContext(applyCtx).Apply(function)
+	observeStage(stageTerraformApply, start)
+	if err != nil {
+		endSpan(span, err)
+		return newLambdaStageError(categoryTerraform, "Terraform apply", function.Name, errors.Wrap(err, "failed to run Terraform apply"))
+	}
+
+	result, err := getLambdaResult(tf.WithContext(applyCtx), function.Name)
+	endSpan(span, err)
+	if err != nil {
+		return newLambdaStageError(categoryTerraform, "Terraform apply", function.Name, errors.Wrap(err, "failed to get Terraform outputs"))
+	}
+	result.Alias = function.Alias
+
+	err = checkLease(ctx, "Release lambda version")
+	if err != nil {
+		return err
+	}
+
+	_, span = startSpan(ctx, "release lambda", attribute.String("version", result.Version))
+	err = releaseLambda(ctx, result, smokeTestPayload, logger)
+	endSpan(span, err)
+	deployment.Lambdas = append(deployment.Lambdas, *result)
+	if err != nil {
+		return newLambdaStageError(categoryRelease, "Release lambda version", function.Name, errors.Wrap(err, "failed to release lambda version"))
+	}
+
+	logger.With(
+		"lambda_arn", result.ARN,
+		"lambda_version", result.Version,
+		"lambda_last_modified", result.LastModified,
+	).Infof("Successfully deployed lambda function")
+
+	return nil
+}
+
+// getLambdaResult collects the deployed lambda function details from the Terraform outputs.
+func getLambdaResult(tf *terraform.Cmd, lambdaName string) (*model.LambdaResult, error) {
+	outputs, err := tf.Outputs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.LambdaResult{
+		Name:         lambdaName,
+		ARN:          fmt.Sprintf("%v", outputs["lambda_arn"]),
+		Version:      fmt.Sprintf("%v", outputs["lambda_version"]),
+		LastModified: fmt.Sprintf("%v", outputs["lambda_last_modified"]),
+		Outputs:      outputs,
+	}, nil
+}
+
+// newPlanResult returns the plan result of the lambda function from the plan summary.
+func newPlanResult(lambdaName string, plan *terraform.PlanSummary) model.PlanResult {
+	return model.PlanResult{
+		Lambda:    lambdaName,
+		Summary:   plan.String(),
+		Resources: plan.Resources,
+		Output:    plan.Output,
+	}
+}
+
+// newFunction returns the lambda function deployed from the bundle function with the given
+// manifest name, whose zip file is named after it.
+func newFunction(manifestName string, lambda apps.FunctionData, bundleName string) model.Function {
+	return model.Function{
+		Name:         lambda.Name,
+		ManifestName: manifestName,
+		Environment:  os.Getenv("Environment"),
+		Runtime:      lambda.Runtime,
+		Handler:      lambda.Handler,
+		ZipFile:      fmt.Sprintf("%s.zip", manifestName),
+		BundleName:   bundleName,
+		Alias:        lambdaAlias(),
+	}
+}
+
+// getFunctions returns the lambda functions deployed from the bundle.
+func getFunctions(deployment *model.Deployment, bundleName string) []model.Function {
+	var functions []model.Function
+	for manifestName, lambda := range deployment.DeployData.LambdaFunctions {
+		functions = append(functions, newFunction(manifestName, lambda, bundleName))
+	}
+
+	return functions
+}
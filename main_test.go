@@ -0,0 +1,108 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// fakeTerraform stands in for the terraform binary, reporting its version and an empty plan.
+const fakeTerraform = `#!/bin/sh
+case "$1" in
+version) echo '{"terraform_version": "1.2.0", "provider_selections": {}}' ;;
+plan) echo 'Plan: 2 to add, 0 to change, 0 to destroy.' ;;
+esac
+`
+
+// newTestBundle returns the content of an app bundle with a lambda function and a static asset.
+func newTestBundle(t *testing.T) []byte {
+	files := map[string]string{
+		"manifest.json": `{
+			"app_id": "hello-world",
+			"version": "1.0.0",
+			"homepage_url": "https://example.com",
+			"aws_lambda": {"functions": [{"path": "/", "name": "hello", "handler": "hello", "runtime": "go1.x"}]}
+		}`,
+		"hello.zip":       "function",
+		"static/icon.png": "icon",
+	}
+
+	var buffer bytes.Buffer
+	writer := zip.NewWriter(&buffer)
+	for name, content := range files {
+		file, err := writer.Create(name)
+		require.NoError(t, err)
+		_, err = file.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	return buffer.Bytes()
+}
+
+func TestHandleBundleDeploymentDryRun(t *testing.T) {
+	bundle := newTestBundle(t)
+
+	var lock sync.Mutex
+	var writes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/bundles/hello-world.zip":
+			_, _ = w.Write(bundle)
+		case r.Method == http.MethodGet || r.Method == http.MethodHead:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`<Error><Code>NoSuchKey</Code><Message>Not found</Message></Error>`))
+		default:
+			lock.Lock()
+			writes = append(writes, r.Method+" "+r.URL.Path)
+			lock.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	binDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(binDir, "terraform"), []byte(fakeTerraform), 0700))
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	t.Setenv("AWSEndpoint", server.URL)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "test")
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	t.Setenv("AppsBundleBucketName", "bundles")
+	t.Setenv("StaticBucket", "static")
+	t.Setenv("TerraformStateBucket", "state")
+	t.Setenv("Environment", "test")
+	t.Setenv("TempDir", t.TempDir())
+	t.Setenv("TerraformTemplateDir", t.TempDir())
+
+	session := session.New(aws.NewConfig().
+		WithEndpoint(server.URL).
+		WithS3ForcePathStyle(true).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("test", "test", "")))
+	logger := appsutils.NewTestLogger()
+	progress := &deploymentProgress{bundle: "hello-world.zip", logger: logger}
+
+	deployment, err := handleBundleDeployment(context.Background(), "hello-world.zip", session, true, progress, logger)
+	require.NoError(t, err)
+	require.NotNil(t, deployment)
+	assert.True(t, deployment.DryRun)
+	require.Len(t, deployment.Plans, 1)
+	assert.Equal(t, "hello-world_1-0-0_hello", deployment.Plans[0].Lambda)
+	assert.Empty(t, writes, "a dry run must not write to S3")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	// metricsNamespace prefixes every exported metric.
+	metricsNamespace = "mattermost_apps_deployer"
+	// metricsJob is the job the metrics are pushed to the Pushgateway as.
+	metricsJob = "mattermost_apps_deployer"
+)
+
+const (
+	bundleStatusDiscovered = "discovered"
+	bundleStatusDeployed   = "deployed"
+	bundleStatusPlanned    = "planned"
+	bundleStatusSkipped    = "skipped"
+	bundleStatusFailed     = "failed"
+)
+
+const (
+	stageDownload       = "download"
+	stageUnzip          = "unzip"
+	stageUpload         = "upload"
+	stageTerraformInit  = "terraform_init"
+	stageTerraformPlan  = "terraform_plan"
+	stageTerraformApply = "terraform_apply"
+)
+
+// metricsRegistry holds the metrics of the run. It is exported once at the end of the run.
+var metricsRegistry = prometheus.NewRegistry()
+
+var (
+	bundlesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "bundles_total",
+		Help:      "Number of app bundles by status.",
+	}, []string{"status"})
+
+	stageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "stage_duration_seconds",
+		Help:      "Duration of the bundle deployment stages.",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"stage"})
+
+	uploadedBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "uploaded_bytes_total",
+		Help:      "Number of bytes of static assets and manifests uploaded.",
+	})
+
+	skippedBytesTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "skipped_bytes_total",
+		Help:      "Number of bytes of static assets not uploaded because they were unchanged.",
+	})
+
+	notificationFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "notification_failures_total",
+		Help:      "Number of notifications which could not be delivered, by event.",
+	}, []string{"event"})
+
+	runDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the last deployer run.",
+	})
+
+	lastRunTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_run_timestamp_seconds",
+		Help:      "Unix timestamp of the end of the last deployer run.",
+	})
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		bundlesTotal,
+		stageDuration,
+		uploadedBytesTotal,
+		skippedBytesTotal,
+		notificationFailuresTotal,
+		runDuration,
+		lastRunTimestamp,
+	)
+	// Export a zero value for every status so that rate based alerts work from the first run.
+	for _, status := range []string{bundleStatusDiscovered, bundleStatusDeployed, bundleStatusPlanned, bundleStatusSkipped, bundleStatusFailed} {
+		bundlesTotal.WithLabelValues(status)
+	}
+}
+
+// observeStage records the duration of the deployment stage started at the given time.
+func observeStage(stage string, start time.Time) {
+	stageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// recordSummaryMetrics records the outcome of the run.
+func recordSummaryMetrics(summary *runSummary) {
+	bundlesTotal.WithLabelValues(bundleStatusDeployed).Add(float64(len(summary.Deployed)))
+	bundlesTotal.WithLabelValues(bundleStatusPlanned).Add(float64(len(summary.Planned)))
+	bundlesTotal.WithLabelValues(bundleStatusSkipped).Add(float64(len(summary.Skipped)))
+	bundlesTotal.WithLabelValues(bundleStatusFailed).Add(float64(len(summary.Failed)))
+	runDuration.Set(summary.Duration.Seconds())
+	lastRunTimestamp.SetToCurrentTime()
+}
+
+// exportMetrics pushes the metrics of the run to the Pushgateway at MetricsPushgatewayURL, grouped
+// by environment, and writes them to the node-exporter textfile at MetricsTextfile. Both are
+// optional.
+func exportMetrics() error {
+	if os.Getenv("MetricsPushgatewayURL") != "" {
+		err := push.New(os.Getenv("MetricsPushgatewayURL"), metricsJob).
+			Gatherer(metricsRegistry).
+			Grouping("environment", os.Getenv("Environment")).
+			Push()
+		if err != nil {
+			return errors.Wrap(err, "failed to push metrics to the Pushgateway")
+		}
+	}
+
+	if os.Getenv("MetricsTextfile") != "" {
+		err := prometheus.WriteToTextfile(os.Getenv("MetricsTextfile"), metricsRegistry)
+		if err != nil {
+			return errors.Wrap(err, "failed to write metrics textfile")
+		}
+	}
+
+	return nil
+}
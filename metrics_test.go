@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportMetrics(t *testing.T) {
+	recordSummaryMetrics(&runSummary{
+		Duration: time.Minute,
+		Deployed: []bundleSummary{{Bundle: "hello-world.zip"}},
+		Failed:   []bundleSummary{{Bundle: "broken.zip"}},
+	})
+
+	t.Run("textfile", func(t *testing.T) {
+		textfile := filepath.Join(t.TempDir(), "deployer.prom")
+		t.Setenv("MetricsTextfile", textfile)
+
+		require.NoError(t, exportMetrics())
+
+		content, err := os.ReadFile(textfile)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), `mattermost_apps_deployer_bundles_total{status="deployed"} 1`)
+		assert.Contains(t, string(content), `mattermost_apps_deployer_bundles_total{status="failed"} 1`)
+		assert.Contains(t, string(content), "mattermost_apps_deployer_run_duration_seconds 60")
+	})
+
+	t.Run("pushgateway", func(t *testing.T) {
+		var path string
+		var body []byte
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusOK)
+		}))
+		defer server.Close()
+		t.Setenv("MetricsPushgatewayURL", server.URL)
+		t.Setenv("Environment", "test")
+
+		require.NoError(t, exportMetrics())
+		assert.Equal(t, "/metrics/job/mattermost_apps_deployer/environment/test", path)
+		assert.NotEmpty(t, body)
+	})
+
+	t.Run("pushgateway unavailable", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+		t.Setenv("MetricsPushgatewayURL", server.URL)
+
+		assert.Error(t, exportMetrics())
+	})
+}
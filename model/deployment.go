@@ -0,0 +1,103 @@
+package mode
+
+import (
+	"time"
+
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+)
+
+// Deployment covers the result of an app bundle deployment.
+type Deployment struct {
+	Bundle           string              `json:"bundle"`
+	DeployData       *apps.DeployData    `json:"deploy_data"`
+	Manifest         *appsmodel.Manifest `json:"manifest"`
+	TerraformVersion string              `json:"terraform_version,omitempty"`
+	ProviderVersions map[string]string   `json:"provider_versions,omitempty"`
+	Lambdas          []LambdaResult      `json:"lambdas,omitempty"`
+	OrphanedLambdas  []string            `json:"orphaned_lambdas,omitempty"`
+	Duration         time.Duration       `json:"duration"`
+	DryRun           bool                `json:"dry_run"`
+	Plans            []PlanResult        `json:"plans,omitempty"`
+	// StaticAssets covers the upload of the static assets of the bundle.
+	StaticAssets *UploadResult `json:"static_assets,omitempty"`
+	// ResumedStages are the stages completed by a previous attempt, which were skipped.
+	ResumedStages []string `json:"resumed_stages,omitempty"`
+	// Compensated is true if the changes of a failed transactional deployment were reverted.
+	Compensated bool `json:"compensated,omitempty"`
+}
+
+// LambdaResult covers the result of a lambda function deployment as reported by the
+// Terraform outputs.
+type LambdaResult struct {
+	Name            string `json:"name"`
+	ARN             string `json:"arn"`
+	Version         string `json:"version"`
+	LastModified    string `json:"last_modified"`
+	Alias           string `json:"alias"`
+	PreviousVersion string `json:"previous_version,omitempty"`
+	SmokeTest       string `json:"smoke_test,omitempty"`
+
+	// Outputs are the Terraform outputs of the lambda function deployment.
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// UploadResult covers the static assets uploaded by a deployment, and the ones skipped because
+// the static bucket already held identical objects.
+type UploadResult struct {
+	UploadedFiles int   `json:"uploaded_files"`
+	UploadedBytes int64 `json:"uploaded_bytes"`
+	SkippedFiles  int   `json:"skipped_files"`
+	SkippedBytes  int64 `json:"skipped_bytes"`
+}
+
+// PlanResult covers the Terraform plan of a lambda function in dry run mode.
+type PlanResult struct {
+	Lambda    string   `json:"lambda"`
+	Summary   string   `json:"summary"`
+	Resources []string `json:"resources,omitempty"`
+	Output    string   `json:"output"`
+}
+
+// DeploymentRecord covers the app version and lambda functions last deployed for an app in an
+// environment.
+type DeploymentRecord struct {
+	AppID       string     `json:"app_id"`
+	Version     string     `json:"version"`
+	Bundle      string     `json:"bundle"`
+	Environment string     `json:"environment"`
+	Lambdas     []Function `json:"lambdas"`
+	DeployedAt  time.Time  `json:"deployed_at"`
+
+	// PreviousBundle is the bundle of the app version deployed before, which a rollback redeploys.
+	PreviousBundle string `json:"previous_bundle,omitempty"`
+	// RetiredVersions are the app versions superseded by later deployments, whose static assets
+	// and manifests are kept until their retention period is over.
+	RetiredVersions []RetiredVersion `json:"retired_versions,omitempty"`
+}
+
+// RetiredVersion covers an app version superseded by a later deployment.
+type RetiredVersion struct {
+	Version   string    `json:"version"`
+	Bundle    string    `json:"bundle"`
+	RetiredAt time.Time `json:"retired_at"`
+	// Lambdas are the lambda functions of the version, which servers still using its manifest
+	// invoke, and which are destroyed with its static assets once its retention period is over.
+	Lambdas []Function `json:"lambdas,omitempty"`
+}
+
+// Checkpoint covers the stages of a bundle deployment completed in an environment, so that a
+// failed deployment resumes at the stage which failed.
+type Checkpoint struct {
+	Bundle      string `json:"bundle"`
+	Environment string `json:"environment"`
+	// ETag is the ETag of the bundle object the stages were completed for. The checkpoint is
+	// discarded if the bundle was uploaded again since.
+	ETag      string         `json:"etag"`
+	Stages    []string       `json:"stages"`
+	Lambdas   []LambdaResult `json:"lambdas,omitempty"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	// StagedAssets are the static assets created by the deployment, which are removed if a
+	// transactional deployment fails.
+	StagedAssets []string `json:"staged_assets,omitempty"`
+}
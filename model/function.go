@@ -2,10 +2,14 @@ package mode
 
 // Function covers the lambda function object.
 type Function struct {
-	Name        string
-	ZipFile     string
-	BundleName  string
-	Handler     string
-	Runtime     string
-	Environment string
+	Name string `json:"name"`
+	// ManifestName is the unversioned name of the function in the app manifest, which Name is
+	// generated from with the app ID and version.
+	ManifestName string `json:"manifest_name,omitempty"`
+	ZipFile      string `json:"zip_file"`
+	BundleName   string `json:"bundle_name"`
+	Handler      string `json:"handler"`
+	Runtime      string `json:"runtime"`
+	Environment  string `json:"environment"`
+	Alias        string `json:"alias"`
 }
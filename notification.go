@@ -2,94 +2,279 @@ package main
 
 import (
 	"bytes"
-	"encoding/json"
 	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
 
-	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
-	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+	model "github.com/mattermost/mattermost-apps/model"
 	"github.com/pkg/errors"
 )
 
-func send(webhookURL string, payload mmmodel.CommandResponse) error {
-	marshalContent, _ := json.Marshal(payload)
-	var jsonStr = []byte(marshalContent)
-	req, err := http.NewRequest("POST", webhookURL, bytes.NewBuffer(jsonStr))
-	req.Header.Set("X-Custom-Header", "aws-sns")
+const (
+	// notificationTimeout is the timeout of a single notification request.
+	notificationTimeout = 10 * time.Second
+	// notificationMaxAttempts is the number of attempts made to deliver a notification.
+	notificationMaxAttempts = 5
+)
+
+// Attachment colors of the notifications, available to the templates as .Colors and also used by
+// the slash command responses.
+const (
+	colorDeployment = "#006400"
+	colorPlan       = "#1E90FF"
+	colorError      = "#FF0000"
+)
+
+var (
+	// notificationBackoffBase is the initial delay between notification delivery attempts.
+	notificationBackoffBase = time.Second
+	// notificationBackoffMax is the maximum delay between notification delivery attempts.
+	notificationBackoffMax = 30 * time.Second
+)
+
+// send posts the JSON body to the URL, retrying transient failures. If the notification cannot be
+// delivered it is spooled to a local file so that it is not lost.
+func send(url string, body []byte, headers map[string]string) error {
+	err := postWithRetry(url, body, headers)
+	if err != nil {
+		spoolFile, spoolErr := spoolNotification(body)
+		if spoolErr != nil {
+			return errors.Wrapf(err, "failed to spool notification: %s", spoolErr)
+		}
+		return errors.Wrapf(err, "notification spooled to %s", spoolFile)
+	}
+
+	return nil
+}
+
+// postWithRetry posts the body to the URL, retrying network errors, rate limiting and server
+// errors with exponential backoff and jitter.
+func postWithRetry(url string, body []byte, headers map[string]string) error {
+	client := &http.Client{Timeout: notificationTimeout}
+
+	var err error
+	for attempt := 1; attempt <= notificationMaxAttempts; attempt++ {
+		var retryable bool
+		retryable, err = post(client, url, body, headers)
+		if err == nil {
+			return nil
+		}
+		if !retryable || attempt == notificationMaxAttempts {
+			break
+		}
+
+		time.Sleep(backoff(attempt))
+	}
+
+	return errors.Wrapf(err, "failed to deliver notification")
+}
+
+// post posts the body to the URL once, and returns whether a failure may be retried.
+func post(client *http.Client, url string, body []byte, headers map[string]string) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "failed to create notification request")
+	}
 	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return err
+		return true, err
 	}
 	defer resp.Body.Close()
-	return nil
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = errors.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
 }
 
-func sendAppDeploymentNotification(deployData *apps.DeployData, bundle string) error {
-	var fields []*mmmodel.SlackAttachmentField
+// request sends a single JSON request and returns the response body if the request succeeded.
+func request(method, url string, body []byte, headers map[string]string) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
 
-	fields = append(fields, &mmmodel.SlackAttachmentField{
-		Title: "Name",
-		Value: fmt.Sprintf("[%s](%s)", deployData.Manifest.DisplayName, deployData.Manifest.HomepageURL),
-		Short: true,
-	})
-	fields = append(fields, &mmmodel.SlackAttachmentField{
-		Title: "ID",
-		Value: fmt.Sprintf("`%s`", deployData.Manifest.AppID),
-		Short: true,
-	})
-	fields = append(fields, &mmmodel.SlackAttachmentField{
-		Title: "Version",
-		Value: deployData.Manifest.Version,
-		Short: true,
-	})
-	fields = append(fields, &mmmodel.SlackAttachmentField{
-		Title: "Bundle",
-		Value: fmt.Sprintf("`%s`", bundle),
-		Short: true,
-	})
+	client := &http.Client{Timeout: notificationTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	fields = append(fields, &mmmodel.SlackAttachmentField{Title: "Environment", Value: os.Getenv("Environment"), Short: false})
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read response")
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, errors.Errorf("unexpected status code %d: %s", resp.StatusCode, strings.TrimSpace(string(respBody)))
+	}
 
-	attachment := &mmmodel.SlackAttachment{
-		Color:  "#006400",
-		Fields: fields,
-		Title:  "A Mattermost apps was successfully deployed/updated",
+	return respBody, nil
+}
+
+// backoff returns the delay before the next attempt, using exponential backoff with full jitter.
+func backoff(attempt int) time.Duration {
+	delay := notificationBackoffBase << (attempt - 1)
+	if delay <= 0 || delay > notificationBackoffMax {
+		delay = notificationBackoffMax
+	}
+
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// spoolNotification writes a notification which could not be delivered to the directory set by
+// NotificationSpoolDir, defaulting to the notifications directory in TempDir.
+func spoolNotification(body []byte) (string, error) {
+	spoolDir := os.Getenv("NotificationSpoolDir")
+	if spoolDir == "" {
+		spoolDir = path.Join(os.Getenv("TempDir"), "notifications")
+	}
+
+	err := os.MkdirAll(spoolDir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	spoolFile := path.Join(spoolDir, fmt.Sprintf("%d.json", time.Now().UnixNano()))
+	err = os.WriteFile(spoolFile, body, 0600)
+	if err != nil {
+		return "", err
+	}
+
+	return spoolFile, nil
+}
+
+// maxPlanOutputLength is the maximum length of the plan output included in plan notifications.
+const maxPlanOutputLength = 12000
+
+// sendAppDeploymentNotification sends the deployment notification, or the plan notification if the
+// bundle was deployed in dry run mode.
+func sendAppDeploymentNotification(deployment *model.Deployment) error {
+	event := eventDeployment
+	if deployment.DryRun {
+		event = eventPlan
 	}
 
-	payload := mmmodel.CommandResponse{
-		Username:    "Mattermost Apps Deployer",
-		IconURL:     "https://cdn-images-1.medium.com/max/1200/1*9860tn6_CPEPnBxF1wIpmw@2x.jpeg",
-		Attachments: []*mmmodel.SlackAttachment{attachment},
+	data := newTemplateData(event)
+	data.Deployment = deployment
+
+	payload, err := renderNotification(data)
+	if err != nil {
+		return errors.Wrapf(err, "failed to render %s notification", event)
 	}
-	err := send(os.Getenv("MattermostNotificationsHook"), payload)
+
+	err = notify(&notification{
+		Event:       event,
+		Environment: data.Environment,
+		Deployment:  deployment,
+		Payload:     payload,
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed tο send Mattermost request payload")
+		return errors.Wrapf(err, "failed to send %s notification", event)
 	}
 	return nil
 }
 
 func sendMattermostErrorNotification(errorMessage error, message string) error {
-	attachment := &mmmodel.SlackAttachment{
-		Color: "#FF0000",
-		Fields: []*mmmodel.SlackAttachmentField{
-			{Title: message, Short: false},
-			{Title: "Error Message", Value: errorMessage.Error(), Short: false},
-			{Title: "Environment", Value: os.Getenv("Environment"), Short: true},
-		},
+	data := newTemplateData(eventError)
+	data.Message = message
+	data.Error = errorMessage.Error()
+	if deployErr := getDeploymentError(errorMessage); deployErr != nil {
+		data.Failure = deployErr.details()
 	}
 
-	payload := mmmodel.CommandResponse{
-		Username:    "Mattermost Apps Deployer",
-		IconURL:     "https://cdn-images-1.medium.com/max/1200/1*9860tn6_CPEPnBxF1wIpmw@2x.jpeg",
-		Attachments: []*mmmodel.SlackAttachment{attachment},
+	payload, err := renderNotification(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to render error notification")
 	}
-	err := send(os.Getenv("MattermostAlertsHook"), payload)
+
+	err = notify(&notification{
+		Event:       eventError,
+		Environment: data.Environment,
+		Message:     message,
+		Error:       data.Error,
+		Failure:     data.Failure,
+		Payload:     payload,
+	})
 	if err != nil {
-		return errors.Wrap(err, "failed tο send Mattermost error payload")
+		return errors.Wrap(err, "failed to send error notification")
 	}
 	return nil
 }
+
+// formatProviderVersions formats the provider versions as a sorted markdown list.
+func formatProviderVersions(providerVersions map[string]string) string {
+	var providers []string
+	for provider, version := range providerVersions {
+		providers = append(providers, fmt.Sprintf("`%s` %s", provider, version))
+	}
+	sort.Strings(providers)
+
+	return strings.Join(providers, "\n")
+}
+
+// formatLambdaResults formats the deployed lambda functions as a markdown list.
+func formatLambdaResults(lambdas []model.LambdaResult) string {
+	var results []string
+	for _, lambda := range lambdas {
+		result := fmt.Sprintf("`%s` version %s (alias `%s`), modified %s", lambda.Name, lambda.Version, lambda.Alias, lambda.LastModified)
+		if lambda.SmokeTest != "" {
+			result = fmt.Sprintf("%s, smoke test %s", result, lambda.SmokeTest)
+		}
+		results = append(results, result)
+	}
+
+	return strings.Join(results, "\n")
+}
+
+// formatPlanSummaries formats the resource changes of each lambda plan as a markdown list.
+func formatPlanSummaries(plans []model.PlanResult) string {
+	var results []string
+	for _, plan := range plans {
+		results = append(results, fmt.Sprintf("- `%s`: %s", plan.Lambda, plan.Summary))
+		for _, resource := range plan.Resources {
+			results = append(results, fmt.Sprintf("  - `%s`", resource))
+		}
+	}
+
+	return strings.Join(results, "\n")
+}
+
+// formatPlanOutputs formats the full plan of each lambda as code blocks, truncated to
+// maxPlanOutputLength.
+func formatPlanOutputs(plans []model.PlanResult) string {
+	var results []string
+	remaining := maxPlanOutputLength
+	for _, plan := range plans {
+		output := plan.Output
+		if len(output) > remaining {
+			output = output[:remaining] + "\n… (truncated)"
+		}
+		remaining -= len(output)
+		results = append(results, fmt.Sprintf("**%s**\n```\n%s\n```", plan.Lambda, output))
+		if remaining <= 0 {
+			break
+		}
+	}
+
+	return strings.Join(results, "\n")
+}
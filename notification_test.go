@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSend(t *testing.T) {
+	notificationBackoffBase = time.Millisecond
+	notificationBackoffMax = 5 * time.Millisecond
+
+	newServer := func(statusCodes ...int) (*httptest.Server, *int32) {
+		var requests int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			i := atomic.AddInt32(&requests, 1) - 1
+			if int(i) < len(statusCodes) {
+				w.WriteHeader(statusCodes[i])
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}))
+		return server, &requests
+	}
+
+	body := []byte(`{"text": "test"}`)
+
+	t.Run("success", func(t *testing.T) {
+		server, requests := newServer()
+		defer server.Close()
+
+		require.NoError(t, send(server.URL, body, nil))
+		assert.Equal(t, int32(1), *requests)
+	})
+
+	t.Run("retries transient failures", func(t *testing.T) {
+		server, requests := newServer(http.StatusBadGateway, http.StatusTooManyRequests)
+		defer server.Close()
+
+		require.NoError(t, send(server.URL, body, nil))
+		assert.Equal(t, int32(3), *requests)
+	})
+
+	t.Run("spools after client errors", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		os.Setenv("NotificationSpoolDir", spoolDir)
+		defer os.Unsetenv("NotificationSpoolDir")
+
+		server, requests := newServer(http.StatusBadRequest)
+		defer server.Close()
+
+		require.Error(t, send(server.URL, body, nil))
+		assert.Equal(t, int32(1), *requests)
+
+		files, err := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+
+	t.Run("spools after exhausting retries", func(t *testing.T) {
+		spoolDir := t.TempDir()
+		os.Setenv("NotificationSpoolDir", spoolDir)
+		defer os.Unsetenv("NotificationSpoolDir")
+
+		statusCodes := make([]int, notificationMaxAttempts)
+		for i := range statusCodes {
+			statusCodes[i] = http.StatusInternalServerError
+		}
+		server, requests := newServer(statusCodes...)
+		defer server.Close()
+
+		require.Error(t, send(server.URL, body, nil))
+		assert.Equal(t, int32(notificationMaxAttempts), *requests)
+
+		files, err := filepath.Glob(filepath.Join(spoolDir, "*.json"))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+// notificationEvent is the type of event a notification is sent for.
+type notificationEvent string
+
+const (
+	// eventDeployment is sent when an app bundle was deployed.
+	eventDeployment notificationEvent = "deployment"
+	// eventPlan is sent when an app bundle was planned in dry run mode.
+	eventPlan notificationEvent = "plan"
+	// eventError is sent when a deployment failed.
+	eventError notificationEvent = "error"
+	// eventSummary is sent at the end of a run with the outcome of every bundle.
+	eventSummary notificationEvent = "summary"
+	// eventProgress routes the live deployment progress posts to a mattermost-api sink.
+	eventProgress notificationEvent = "progress"
+)
+
+const (
+	sinkMattermostWebhook = "mattermost-webhook"
+	sinkMattermostAPI     = "mattermost-api"
+	sinkWebhook           = "webhook"
+	sinkSlack             = "slack"
+	sinkStdout            = "stdout"
+	sinkFile              = "file"
+)
+
+// notification covers a deployment event sent to the configured notifiers.
+type notification struct {
+	Event       notificationEvent `json:"event"`
+	Environment string            `json:"environment"`
+	Message     string            `json:"message,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	Deployment  *model.Deployment `json:"deployment,omitempty"`
+	Summary     *runSummary       `json:"summary,omitempty"`
+	Failure     *errorDetails     `json:"failure,omitempty"`
+
+	// Payload is the rendered message posted to chat sinks.
+	Payload mmmodel.CommandResponse `json:"-"`
+}
+
+// Notifier delivers notifications to a sink.
+type Notifier interface {
+	Notify(n *notification) error
+}
+
+// sinkConfig covers the configuration of a notification sink.
+type sinkConfig struct {
+	Type      string `json:"type"`
+	URL       string `json:"url,omitempty"`
+	ServerURL string `json:"server_url,omitempty"`
+	Token     string `json:"token,omitempty"`
+	ChannelID string `json:"channel_id,omitempty"`
+	Path      string `json:"path,omitempty"`
+}
+
+var (
+	notifiersOnce sync.Once
+	notifiers     map[notificationEvent][]Notifier
+	notifiersErr  error
+)
+
+// getNotifiers returns the notifiers configured for the event.
+func getNotifiers(event notificationEvent) ([]Notifier, error) {
+	notifiersOnce.Do(func() {
+		notifiers, notifiersErr = loadNotifiers()
+	})
+	if notifiersErr != nil {
+		return nil, errors.Wrap(notifiersErr, "failed to load notifiers")
+	}
+
+	return notifiers[event], nil
+}
+
+// checkNotifiers loads the configured notifiers, so that a missing or invalid sink is reported
+// when the deployer starts rather than losing every notification.
+func checkNotifiers() error {
+	_, err := getNotifiers(eventError)
+	return err
+}
+
+// notify sends the notification to every notifier configured for its event.
+func notify(n *notification) error {
+	eventNotifiers, err := getNotifiers(n.Event)
+	if err != nil {
+		notificationFailuresTotal.WithLabelValues(string(n.Event)).Inc()
+		return newStageError(categoryNotification, "Load notifiers", err)
+	}
+
+	var failures []string
+	for _, notifier := range eventNotifiers {
+		err := notifier.Notify(n)
+		if err != nil {
+			notificationFailuresTotal.WithLabelValues(string(n.Event)).Inc()
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		err = errors.Errorf("failed to deliver %s notification: %s", n.Event, strings.Join(failures, "; "))
+		return newStageError(categoryNotification, "Send notification", err)
+	}
+
+	return nil
+}
+
+// loadNotifiers returns the notifiers of each event. Sinks are configured through the
+// NotificationSinks JSON object, mapping each event to a list of sinks. If it is not set,
+// deployments, plans and run summaries are sent to MattermostNotificationsHook and errors to
+// MattermostAlertsHook.
+func loadNotifiers() (map[notificationEvent][]Notifier, error) {
+	sinks := map[notificationEvent][]sinkConfig{
+		eventDeployment: {{Type: sinkMattermostWebhook, URL: os.Getenv("MattermostNotificationsHook")}},
+		eventPlan:       {{Type: sinkMattermostWebhook, URL: os.Getenv("MattermostNotificationsHook")}},
+		eventError:      {{Type: sinkMattermostWebhook, URL: os.Getenv("MattermostAlertsHook")}},
+		eventSummary:    {{Type: sinkMattermostWebhook, URL: os.Getenv("MattermostNotificationsHook")}},
+	}
+	if os.Getenv("NotificationSinks") != "" {
+		sinks = map[notificationEvent][]sinkConfig{}
+		err := json.Unmarshal([]byte(os.Getenv("NotificationSinks")), &sinks)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse NotificationSinks")
+		}
+	}
+
+	result := make(map[notificationEvent][]Notifier, len(sinks))
+	for event, configs := range sinks {
+		for _, config := range configs {
+			notifier, err := newNotifier(config)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid %s notification sink", event)
+			}
+			result[event] = append(result[event], notifier)
+		}
+	}
+
+	return result, nil
+}
+
+// newNotifier creates the notifier of the given sink configuration.
+func newNotifier(config sinkConfig) (Notifier, error) {
+	switch config.Type {
+	case sinkMattermostWebhook:
+		if config.URL == "" {
+			return nil, errors.New("mattermost webhook sink requires a url")
+		}
+		return &mattermostWebhookNotifier{url: config.URL}, nil
+	case sinkMattermostAPI:
+		if config.ServerURL == "" || config.Token == "" || config.ChannelID == "" {
+			return nil, errors.New("mattermost api sink requires a server_url, token and channel_id")
+		}
+		return &mattermostAPINotifier{
+			serverURL: strings.TrimSuffix(config.ServerURL, "/"),
+			token:     config.Token,
+			channelID: config.ChannelID,
+		}, nil
+	case sinkWebhook:
+		if config.URL == "" {
+			return nil, errors.New("webhook sink requires a url")
+		}
+		return &webhookNotifier{url: config.URL}, nil
+	case sinkSlack:
+		if config.URL == "" {
+			return nil, errors.New("slack sink requires a url")
+		}
+		return &slackNotifier{url: config.URL}, nil
+	case sinkStdout:
+		return &fileNotifier{}, nil
+	case sinkFile:
+		if config.Path == "" {
+			return nil, errors.New("file sink requires a path")
+		}
+		return &fileNotifier{path: config.Path}, nil
+	default:
+		return nil, errors.Errorf("unknown notification sink type %q", config.Type)
+	}
+}
+
+// mattermostWebhookNotifier posts notifications to a Mattermost incoming webhook.
+type mattermostWebhookNotifier struct {
+	url string
+}
+
+// Notify posts the notification payload to the webhook.
+func (m *mattermostWebhookNotifier) Notify(n *notification) error {
+	body, err := json.Marshal(n.Payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification payload")
+	}
+
+	return send(m.url, body, map[string]string{"X-Custom-Header": "aws-sns"})
+}
+
+// mattermostAPINotifier creates posts through the Mattermost REST API with a bot access token.
+type mattermostAPINotifier struct {
+	serverURL string
+	token     string
+	channelID string
+}
+
+// Notify creates a post with the notification payload in the configured channel.
+func (m *mattermostAPINotifier) Notify(n *notification) error {
+	post := &mmmodel.Post{
+		ChannelId: m.channelID,
+		Message:   n.Payload.Text,
+	}
+	post.AddProp("attachments", n.Payload.Attachments)
+
+	body, err := json.Marshal(post)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification post")
+	}
+
+	return send(fmt.Sprintf("%s/api/v4/posts", m.serverURL), body, m.headers())
+}
+
+func (m *mattermostAPINotifier) headers() map[string]string {
+	return map[string]string{"Authorization": "Bearer " + m.token}
+}
+
+// createPost creates the post once, without retrying or spooling, and returns the created post.
+func (m *mattermostAPINotifier) createPost(post *mmmodel.Post) (*mmmodel.Post, error) {
+	body, err := json.Marshal(post)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal post")
+	}
+
+	respBody, err := request(http.MethodPost, fmt.Sprintf("%s/api/v4/posts", m.serverURL), body, m.headers())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create post")
+	}
+
+	var created mmmodel.Post
+	err = json.Unmarshal(respBody, &created)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse created post")
+	}
+
+	return &created, nil
+}
+
+// patchPost replaces the message of the post.
+func (m *mattermostAPINotifier) patchPost(postID, message string) error {
+	body, err := json.Marshal(&mmmodel.PostPatch{Message: &message})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal post patch")
+	}
+
+	_, err = request(http.MethodPut, fmt.Sprintf("%s/api/v4/posts/%s/patch", m.serverURL, postID), body, m.headers())
+	if err != nil {
+		return errors.Wrap(err, "failed to patch post")
+	}
+
+	return nil
+}
+
+// webhookNotifier posts the notification as generic JSON to a webhook.
+type webhookNotifier struct {
+	url string
+}
+
+// Notify posts the notification to the webhook.
+func (w *webhookNotifier) Notify(n *notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification")
+	}
+
+	return send(w.url, body, nil)
+}
+
+// slackNotifier posts notifications to a Slack incoming webhook.
+type slackNotifier struct {
+	url string
+}
+
+// Notify posts the notification payload to the Slack webhook.
+func (s *slackNotifier) Notify(n *notification) error {
+	body, err := json.Marshal(struct {
+		Username    string                     `json:"username,omitempty"`
+		IconURL     string                     `json:"icon_url,omitempty"`
+		Text        string                     `json:"text,omitempty"`
+		Attachments []*mmmodel.SlackAttachment `json:"attachments,omitempty"`
+	}{
+		Username:    n.Payload.Username,
+		IconURL:     n.Payload.IconURL,
+		Text:        n.Payload.Text,
+		Attachments: n.Payload.Attachments,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification payload")
+	}
+
+	return send(s.url, body, nil)
+}
+
+// fileNotifier appends notifications as JSON lines to a file, or writes them to stdout if no
+// path is set.
+type fileNotifier struct {
+	path string
+}
+
+// Notify writes the notification as a JSON line.
+func (f *fileNotifier) Notify(n *notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal notification")
+	}
+	body = append(body, '\n')
+
+	if f.path == "" {
+		_, err = os.Stdout.Write(body)
+		return err
+	}
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to open notification file")
+	}
+	defer file.Close()
+
+	_, err = file.Write(body)
+	if err != nil {
+		return errors.Wrap(err, "failed to write notification file")
+	}
+
+	return nil
+}
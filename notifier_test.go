@@ -0,0 +1,64 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadNotifiers(t *testing.T) {
+	t.Run("default webhooks", func(t *testing.T) {
+		os.Setenv("MattermostNotificationsHook", "https://mattermost.example.com/hooks/notifications")
+		os.Setenv("MattermostAlertsHook", "https://mattermost.example.com/hooks/alerts")
+		defer os.Unsetenv("MattermostNotificationsHook")
+		defer os.Unsetenv("MattermostAlertsHook")
+
+		notifiers, err := loadNotifiers()
+		require.NoError(t, err)
+		require.Len(t, notifiers[eventDeployment], 1)
+		require.Len(t, notifiers[eventError], 1)
+		require.Len(t, notifiers[eventSummary], 1)
+		assert.Equal(t, &mattermostWebhookNotifier{url: "https://mattermost.example.com/hooks/notifications"}, notifiers[eventDeployment][0])
+		assert.Equal(t, &mattermostWebhookNotifier{url: "https://mattermost.example.com/hooks/alerts"}, notifiers[eventError][0])
+	})
+
+	t.Run("missing default webhooks", func(t *testing.T) {
+		_, err := loadNotifiers()
+		assert.Error(t, err)
+	})
+
+	t.Run("configured sinks", func(t *testing.T) {
+		os.Setenv("NotificationSinks", `{
+			"deployment": [
+				{"type": "slack", "url": "https://hooks.slack.com/services/test"},
+				{"type": "stdout"}
+			],
+			"error": [
+				{"type": "mattermost-api", "server_url": "https://mattermost.example.com/", "token": "token", "channel_id": "channel"},
+				{"type": "file", "path": "/tmp/notifications.jsonl"}
+			]
+		}`)
+		defer os.Unsetenv("NotificationSinks")
+
+		notifiers, err := loadNotifiers()
+		require.NoError(t, err)
+		assert.Equal(t, []Notifier{
+			&slackNotifier{url: "https://hooks.slack.com/services/test"},
+			&fileNotifier{},
+		}, notifiers[eventDeployment])
+		assert.Equal(t, []Notifier{
+			&mattermostAPINotifier{serverURL: "https://mattermost.example.com", token: "token", channelID: "channel"},
+			&fileNotifier{path: "/tmp/notifications.jsonl"},
+		}, notifiers[eventError])
+	})
+
+	t.Run("invalid sink", func(t *testing.T) {
+		os.Setenv("NotificationSinks", `{"deployment": [{"type": "pager"}]}`)
+		defer os.Unsetenv("NotificationSinks")
+
+		_, err := loadNotifiers()
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	terraform "github.com/mattermost/mattermost-apps/internal/tools/terraform"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// removeOrphanedLambdas compares the lambda functions of the deployed bundle with the ones of the
+// previously deployed version of the same app by their manifest name, and reports the functions
+// that are no longer part of the app. Lambda names are versioned, so the functions of a previous
+// app version are still invoked by the servers using its manifest: they are retired with the
+// version, and destroyed with its static assets once its retention period is over. Only the
+// orphaned functions of a redeployed app version are destroyed right away. In plan mode the
+// orphaned functions are only planned for destruction and the deployment record is left untouched.
+func removeOrphanedLambdas(ctx context.Context, deployment *model.Deployment, bundleName string, logger appsutils.Logger) error {
+	appID := string(deployment.DeployData.Manifest.AppID)
+	version := string(deployment.DeployData.Manifest.Version)
+	record, err := awsTools.GetDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the previous deployment record")
+	}
+
+	functions := getFunctions(deployment, bundleName)
+
+	if record != nil {
+		for _, function := range orphanedLambdas(record, functions) {
+			deployment.OrphanedLambdas = append(deployment.OrphanedLambdas, function.Name)
+			if record.Version != version {
+				logger.With("lambda_name", function.Name).Infof("Retiring orphaned lambda function with app version %s", record.Version)
+				continue
+			}
+
+			err = destroyLambda(ctx, deployment, function, logger.With("lambda_name", function.Name))
+			if err != nil {
+				return errors.Wrapf(err, "failed to remove orphaned lambda function %s", function.Name)
+			}
+		}
+	}
+
+	if deployment.DryRun {
+		return nil
+	}
+
+	newRecord := &model.DeploymentRecord{
+		AppID:       appID,
+		Version:     version,
+		Bundle:      deployment.Bundle,
+		Environment: os.Getenv("Environment"),
+		Lambdas:     functions,
+		DeployedAt:  time.Now(),
+	}
+	if record != nil {
+		newRecord.PreviousBundle = record.PreviousBundle
+		if record.Bundle != deployment.Bundle {
+			newRecord.PreviousBundle = record.Bundle
+		}
+		newRecord.RetiredVersions = retireVersion(record, newRecord.Version, newRecord.DeployedAt)
+	}
+
+	err = awsTools.PutDeploymentRecord(os.Getenv("TerraformStateBucket"), newRecord)
+	if err != nil {
+		return errors.Wrap(err, "failed to store the deployment record")
+	}
+
+	return nil
+}
+
+// destroyLambda destroys the given lambda function and its Terraform managed resources, or plans
+// their destruction in dry run mode.
+func destroyLambda(ctx context.Context, deployment *model.Deployment, function model.Function, logger appsutils.Logger) error {
+	tf, err := terraform.New(os.Getenv("TerraformTemplateDir"), os.Getenv("TerraformStateBucket"), logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to initiate Terraform")
+	}
+	tf = tf.WithContext(ctx)
+
+	err = tf.Init(function.Name)
+	if err != nil {
+		return errors.Wrap(err, "failed to run Terraform init")
+	}
+
+	if !deployment.DryRun {
+		logger.Infof("Destroying lambda function")
+		err = tf.DestroyFunction(function)
+		if err != nil {
+			return errors.Wrap(err, "failed to run Terraform destroy")
+		}
+		logger.Infof("Successfully destroyed lambda function")
+		return nil
+	}
+
+	logger.Infof("Planning the destruction of lambda function")
+	plan, err := tf.PlanDestroy(function)
+	if err != nil {
+		return errors.Wrap(err, "failed to run Terraform plan -destroy")
+	}
+	deployment.Plans = append(deployment.Plans, newPlanResult(function.Name, plan))
+
+	return nil
+}
+
+// orphanedLambdas returns the lambda functions of the deployment record whose manifest function is
+// not part of the deployed functions anymore. Records stored before the manifest names were
+// recorded are matched by their versioned lambda names.
+func orphanedLambdas(record *model.DeploymentRecord, functions []model.Function) []model.Function {
+	deployed := make(map[string]bool, len(functions))
+	for _, function := range functions {
+		deployed[function.ManifestName] = true
+	}
+
+	var orphaned []model.Function
+	for _, function := range record.Lambdas {
+		manifestName := function.ManifestName
+		if manifestName == "" {
+			for name := range deployed {
+				if apps.LambdaName(appsmodel.AppID(record.AppID), appsmodel.AppVersion(record.Version), name) == function.Name {
+					manifestName = name
+				}
+			}
+		}
+		if !deployed[manifestName] {
+			orphaned = append(orphaned, function)
+		}
+	}
+
+	return orphaned
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+func TestOrphanedLambdas(t *testing.T) {
+	functions := []model.Function{
+		{Name: "hello-world_1-1-0_hello", ManifestName: "hello"},
+		{Name: "hello-world_1-1-0_goodbye", ManifestName: "goodbye"},
+	}
+
+	t.Run("new version", func(t *testing.T) {
+		record := &model.DeploymentRecord{
+			AppID:   "hello-world",
+			Version: "1.0.0",
+			Lambdas: []model.Function{
+				{Name: "hello-world_1-0-0_hello", ManifestName: "hello"},
+				{Name: "hello-world_1-0-0_removed", ManifestName: "removed"},
+			},
+		}
+
+		orphaned := orphanedLambdas(record, functions)
+		require.Len(t, orphaned, 1)
+		assert.Equal(t, "hello-world_1-0-0_removed", orphaned[0].Name)
+	})
+
+	t.Run("record without manifest names", func(t *testing.T) {
+		record := &model.DeploymentRecord{
+			AppID:   "hello-world",
+			Version: "1.0.0",
+			Lambdas: []model.Function{
+				{Name: "hello-world_1-0-0_hello"},
+				{Name: "hello-world_1-0-0_goodbye"},
+				{Name: "hello-world_1-0-0_removed"},
+			},
+		}
+
+		orphaned := orphanedLambdas(record, functions)
+		require.Len(t, orphaned, 1)
+		assert.Equal(t, "hello-world_1-0-0_removed", orphaned[0].Name)
+	})
+}
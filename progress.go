@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// progressModeUpdate updates the deployment post as each stage progresses.
+	progressModeUpdate = "update"
+	// progressModeThread replies in the thread of the deployment post as each stage progresses.
+	progressModeThread = "thread"
+)
+
+// deploymentStage covers a stage of a bundle deployment shown in the progress post.
+type deploymentStage struct {
+	name     string
+	done     bool
+	failed   bool
+	started  time.Time
+	duration time.Duration
+}
+
+// deploymentProgress tracks the stages of a bundle deployment, and reports them in a single
+// Mattermost post, created when the deployment starts and updated, or replied to, as each stage
+// progresses. The post is skipped if no mattermost-api sink is configured for the progress event.
+// Failures to report progress are logged and never fail the deployment.
+type deploymentProgress struct {
+	client *mattermostAPINotifier
+	mode   string
+	bundle string
+	postID string
+	stages []*deploymentStage
+	logger appsutils.Logger
+}
+
+// newDeploymentProgress creates the progress post of the bundle deployment.
+func newDeploymentProgress(bundle string, logger appsutils.Logger) *deploymentProgress {
+	progress := &deploymentProgress{
+		mode:   os.Getenv("ProgressMode"),
+		bundle: bundle,
+		logger: logger,
+	}
+	if progress.mode == "" {
+		progress.mode = progressModeUpdate
+	}
+
+	progressNotifiers, err := getNotifiers(eventProgress)
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to load the progress notifier")
+		return progress
+	}
+	for _, notifier := range progressNotifiers {
+		if client, ok := notifier.(*mattermostAPINotifier); ok {
+			progress.client = client
+			break
+		}
+	}
+	if progress.client == nil {
+		return progress
+	}
+
+	post, err := progress.client.createPost(&mmmodel.Post{
+		ChannelId: progress.client.channelID,
+		Message:   progress.message(""),
+	})
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to create the deployment progress post")
+		progress.client = nil
+		return progress
+	}
+	progress.postID = post.Id
+
+	return progress
+}
+
+// stage marks the current stage as done and starts the given one.
+func (p *deploymentProgress) stage(format string, args ...interface{}) {
+	if len(p.stages) > 0 {
+		current := p.stages[len(p.stages)-1]
+		current.done = true
+		current.duration = time.Since(current.started)
+	}
+	stage := &deploymentStage{name: fmt.Sprintf(format, args...), started: time.Now()}
+	p.stages = append(p.stages, stage)
+
+	if p.client == nil {
+		return
+	}
+
+	if p.mode == progressModeThread {
+		p.reply(fmt.Sprintf(":hourglass: %s", stage.name))
+		return
+	}
+	p.update(p.message(""))
+}
+
+// finish reports the outcome of the deployment.
+func (p *deploymentProgress) finish(err error) {
+	if len(p.stages) > 0 {
+		current := p.stages[len(p.stages)-1]
+		current.done = err == nil
+		current.failed = err != nil
+		current.duration = time.Since(current.started)
+	}
+
+	if p.client == nil {
+		return
+	}
+
+	status := ":white_check_mark: Deployment succeeded"
+	if err != nil {
+		status = fmt.Sprintf(":x: Deployment failed: %s", errorExcerpt(err))
+	}
+
+	if p.mode == progressModeThread {
+		p.reply(status)
+	}
+	p.update(p.message(status))
+}
+
+// message renders the progress post with the status of each stage.
+func (p *deploymentProgress) message(status string) string {
+	lines := []string{fmt.Sprintf("#### Deploying `%s` to %s", p.bundle, os.Getenv("Environment"))}
+	if p.mode != progressModeThread {
+		for _, stage := range p.stages {
+			icon := ":hourglass:"
+			switch {
+			case stage.done:
+				icon = ":white_check_mark:"
+			case stage.failed:
+				icon = ":x:"
+			}
+			lines = append(lines, fmt.Sprintf("%s %s", icon, stage.name))
+		}
+	}
+	if status != "" {
+		lines = append(lines, status)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (p *deploymentProgress) update(message string) {
+	err := p.client.patchPost(p.postID, message)
+	if err != nil {
+		p.logger.WithError(err).Warnf("Failed to update the deployment progress post")
+	}
+}
+
+func (p *deploymentProgress) reply(message string) {
+	_, err := p.client.createPost(&mmmodel.Post{
+		ChannelId: p.client.channelID,
+		RootId:    p.postID,
+		Message:   message,
+	})
+	if err != nil {
+		p.logger.WithError(err).Warnf("Failed to reply to the deployment progress post")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+const (
+	// exitCodeSuccess is returned when every bundle was deployed or planned.
+	exitCodeSuccess = 0
+	// exitCodeSetupError is returned when the deployer failed before deploying any bundle.
+	exitCodeSetupError = 1
+	// exitCodeBundlesFailed is returned when at least one bundle failed to deploy.
+	exitCodeBundlesFailed = 2
+)
+
+const (
+	stageStatusSucceeded = "succeeded"
+	stageStatusFailed    = "failed"
+)
+
+// runReport is the machine-readable result of a deployer run, written to RunReportPath. Durations
+// are reported in seconds.
+type runReport struct {
+	StartedAt   time.Time      `json:"started_at"`
+	FinishedAt  time.Time      `json:"finished_at"`
+	Duration    float64        `json:"duration_seconds"`
+	Environment string         `json:"environment"`
+	DryRun      bool           `json:"dry_run"`
+	ExitCode    int            `json:"exit_code"`
+	Error       string         `json:"error,omitempty"`
+	Failure     *errorDetails  `json:"failure,omitempty"`
+	Bundles     []bundleReport `json:"bundles"`
+}
+
+// bundleReport covers the outcome of every stage of a bundle deployment.
+type bundleReport struct {
+	Bundle          string               `json:"bundle"`
+	Status          string               `json:"status"`
+	SkipReason      string               `json:"skip_reason,omitempty"`
+	AppID           string               `json:"app_id,omitempty"`
+	Version         string               `json:"version,omitempty"`
+	Duration        float64              `json:"duration_seconds"`
+	Stages          []stageReport        `json:"stages,omitempty"`
+	Lambdas         []model.LambdaResult `json:"lambdas,omitempty"`
+	Plans           []model.PlanResult   `json:"plans,omitempty"`
+	OrphanedLambdas []string             `json:"orphaned_lambdas,omitempty"`
+	StaticAssets    *model.UploadResult  `json:"static_assets,omitempty"`
+	ResumedStages   []string             `json:"resumed_stages,omitempty"`
+	Compensated     bool                 `json:"compensated,omitempty"`
+	Error           string               `json:"error,omitempty"`
+	Failure         *errorDetails        `json:"failure,omitempty"`
+}
+
+// stageReport covers the outcome of a bundle deployment stage.
+type stageReport struct {
+	Name     string  `json:"name"`
+	Status   string  `json:"status"`
+	Duration float64 `json:"duration_seconds"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{
+		StartedAt:   time.Now(),
+		Environment: os.Getenv("Environment"),
+		DryRun:      os.Getenv("TerraformApply") != "true",
+		Bundles:     []bundleReport{},
+	}
+}
+
+// addSkipped records the bundles skipped because they were already deployed, or claimed by another
+// deployer.
+func (r *runReport) addSkipped(bundles []string, reason string) {
+	for _, bundle := range bundles {
+		r.Bundles = append(r.Bundles, bundleReport{Bundle: bundle, Status: bundleStatusSkipped, SkipReason: reason})
+	}
+}
+
+// addResult records the outcome of a bundle deployment and its stages.
+func (r *runReport) addResult(bundle string, deployment *model.Deployment, progress *deploymentProgress, duration time.Duration, err error) {
+	result := bundleReport{
+		Bundle:   bundle,
+		Status:   bundleStatusDeployed,
+		Duration: duration.Seconds(),
+	}
+
+	for _, stage := range progress.stages {
+		status := stageStatusSucceeded
+		if stage.failed {
+			status = stageStatusFailed
+		}
+		result.Stages = append(result.Stages, stageReport{Name: stage.name, Status: status, Duration: stage.duration.Seconds()})
+	}
+
+	if deployment != nil {
+		if deployment.Manifest != nil {
+			result.AppID = string(deployment.Manifest.AppID)
+			result.Version = string(deployment.Manifest.Version)
+		}
+		result.Lambdas = deployment.Lambdas
+		result.Plans = deployment.Plans
+		result.OrphanedLambdas = deployment.OrphanedLambdas
+		result.StaticAssets = deployment.StaticAssets
+		result.ResumedStages = deployment.ResumedStages
+		result.Compensated = deployment.Compensated
+		if deployment.DryRun {
+			result.Status = bundleStatusPlanned
+		}
+	}
+
+	if err != nil {
+		result.Status = bundleStatusFailed
+		result.Error = err.Error()
+		if deployErr := getDeploymentError(err); deployErr != nil {
+			result.Failure = deployErr.details()
+		}
+	}
+
+	r.Bundles = append(r.Bundles, result)
+}
+
+// finish records the exit code of the run, and the error which stopped it if any.
+func (r *runReport) finish(exitCode int, err error) {
+	r.FinishedAt = time.Now()
+	r.Duration = r.FinishedAt.Sub(r.StartedAt).Seconds()
+	r.ExitCode = exitCode
+	if err != nil {
+		r.Error = err.Error()
+		if deployErr := getDeploymentError(err); deployErr != nil {
+			r.Failure = deployErr.details()
+		}
+	}
+}
+
+// write writes the report to RunReportPath. It is a no-op if RunReportPath is not set.
+func (r *runReport) write() error {
+	return r.writeTo(os.Getenv("RunReportPath"))
+}
+
+// writeTo writes the report to the given path. It is a no-op if the path is empty.
+func (r *runReport) writeTo(reportPath string) error {
+	if reportPath == "" {
+		return nil
+	}
+
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal run report")
+	}
+
+	err = os.WriteFile(reportPath, content, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write run report")
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	model "github.com/mattermost/mattermost-apps/model"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestRunReport(t *testing.T) {
+	t.Setenv("TerraformApply", "true")
+	reportPath := filepath.Join(t.TempDir(), "report.json")
+	t.Setenv("RunReportPath", reportPath)
+
+	report := newRunReport()
+	report.addSkipped([]string{"old.zip"}, skipReasonDeployed)
+
+	progress := &deploymentProgress{logger: appsutils.NewTestLogger()}
+	progress.stage("Download bundle")
+	progress.stage("Deploy lambda `%s`", "hello-world_v1-0-0_go-function")
+	progress.finish(nil)
+	report.addResult("hello-world.zip", &model.Deployment{
+		Lambdas: []model.LambdaResult{{Name: "hello-world_v1-0-0_go-function", Version: "3", Outputs: map[string]interface{}{"lambda_version": "3"}}},
+	}, progress, time.Minute, nil)
+
+	progress = &deploymentProgress{logger: appsutils.NewTestLogger()}
+	progress.stage("Download bundle")
+	err := classifyBundleError(newStageError(categoryStorage, "Download bundle", errors.New("failed to get s3 object")), "broken.zip")
+	progress.finish(err)
+	report.addResult("broken.zip", nil, progress, time.Second, err)
+
+	summary := &runSummary{Failed: []bundleSummary{{Bundle: "broken.zip"}}}
+	report.finish(summary.exitCode(), nil)
+	require.NoError(t, report.write())
+
+	content, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	var written runReport
+	require.NoError(t, json.Unmarshal(content, &written))
+
+	assert.Equal(t, exitCodeBundlesFailed, written.ExitCode)
+	require.Len(t, written.Bundles, 3)
+	assert.Equal(t, bundleStatusSkipped, written.Bundles[0].Status)
+	assert.Equal(t, skipReasonDeployed, written.Bundles[0].SkipReason)
+
+	assert.Contains(t, string(content), `"duration_seconds": 60`)
+
+	deployed := written.Bundles[1]
+	assert.Equal(t, bundleStatusDeployed, deployed.Status)
+	assert.Equal(t, 60.0, deployed.Duration)
+	require.Len(t, deployed.Stages, 2)
+	assert.Equal(t, stageStatusSucceeded, deployed.Stages[1].Status)
+	assert.Equal(t, "3", deployed.Lambdas[0].Outputs["lambda_version"])
+
+	failed := written.Bundles[2]
+	assert.Equal(t, bundleStatusFailed, failed.Status)
+	assert.Equal(t, "failed to get s3 object", failed.Error)
+	assert.Equal(t, stageStatusFailed, failed.Stages[0].Status)
+	require.NotNil(t, failed.Failure)
+	assert.Equal(t, string(categoryStorage), failed.Failure.Category)
+	assert.Equal(t, "broken.zip", failed.Failure.Bundle)
+}
+
+func TestRunSummaryExitCode(t *testing.T) {
+	assert.Equal(t, exitCodeSuccess, (&runSummary{Deployed: []bundleSummary{{Bundle: "hello-world.zip"}}}).exitCode())
+	assert.Equal(t, exitCodeBundlesFailed, (&runSummary{Failed: []bundleSummary{{Bundle: "broken.zip"}}}).exitCode())
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// defaultLambdaAlias is the alias used for the lambda functions if LambdaAlias is not set.
+	defaultLambdaAlias = "live"
+	// defaultCanaryBakeTime is the canary bake time used if CanaryBakeTime is not set.
+	defaultCanaryBakeTime = 5 * time.Minute
+)
+
+// lambdaAlias returns the configured alias name of the deployed lambda functions.
+func lambdaAlias() string {
+	if alias := os.Getenv("LambdaAlias"); alias != "" {
+		return alias
+	}
+
+	return defaultLambdaAlias
+}
+
+// aliasedManifest returns a copy of the manifest whose lambda functions are invoked through the
+// alias. The Apps plugin invokes the function named after the app ID, version and manifest
+// function name, so qualifying the manifest function names with the alias routes every call to
+// the version the alias points at, rather than to $LATEST which Terraform overwrites on apply.
+// An error is returned if a qualified name would not fit in a lambda function name, since the
+// plugin would then invoke a hashed name instead.
+func aliasedManifest(manifest *appsmodel.Manifest, alias string) (*appsmodel.Manifest, error) {
+	if manifest.AWSLambda == nil {
+		return nil, errors.New("manifest has no aws_lambda section")
+	}
+
+	aliased := *manifest
+	aliased.AWSLambda = &appsmodel.AWSLambda{}
+	for _, function := range manifest.AWSLambda.Functions {
+		qualifiedName := function.Name + ":" + alias
+		invokedName := apps.LambdaName(manifest.AppID, manifest.Version, qualifiedName)
+		if invokedName != apps.LambdaName(manifest.AppID, manifest.Version, function.Name)+":"+alias {
+			return nil, errors.Errorf("lambda function %s is too long to be invoked through alias %s", function.Name, alias)
+		}
+
+		function.Name = qualifiedName
+		aliased.AWSLambda.Functions = append(aliased.AWSLambda.Functions, function)
+	}
+
+	return &aliased, nil
+}
+
+// writeAliasedManifest replaces the manifest file of the unzipped bundle with the manifest whose
+// lambda functions are invoked through the alias, which is the one published.
+func writeAliasedManifest(manifest *appsmodel.Manifest, bundleName string) error {
+	aliased, err := aliasedManifest(manifest, lambdaAlias())
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(aliased, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal manifest")
+	}
+
+	err = os.WriteFile(path.Join(os.Getenv("TempDir"), bundleName, manifestFileName), content, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write manifest")
+	}
+
+	return nil
+}
+
+// releaseLambda runs the smoke test against the newly published lambda version if enabled, then
+// rolls it out. The smoke test invokes the version directly, so that a version failing it is never
+// routed any traffic.
+func releaseLambda(ctx context.Context, result *model.LambdaResult, smokeTestPayload []byte, logger appsutils.Logger) error {
+	if smokeTestEnabled() {
+		err := smokeTestLambda(result, smokeTestPayload, logger)
+		if err != nil {
+			return errors.Wrap(err, "lambda smoke test failed")
+		}
+	}
+
+	err := rolloutLambda(ctx, result, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to roll out lambda version")
+	}
+
+	return nil
+}
+
+// rolloutLambda moves the alias of a deployed lambda function to the newly published version.
+// The published manifest invokes the functions through the alias, so the new version receives no
+// traffic until then.
+// If CanaryWeight is set, the new version first receives that share of the alias traffic for
+// CanaryBakeTime, and is only promoted if it reported at most CanaryMaxErrors errors meanwhile.
+// Otherwise, or if ctx is canceled during the bake time, the alias keeps pointing at the previous
+// version and an error is returned.
+func rolloutLambda(ctx context.Context, result *model.LambdaResult, logger appsutils.Logger) error {
+	previousVersion, err := awsTools.GetLambdaAliasVersion(result.Name, result.Alias)
+	if err != nil {
+		return errors.Wrap(err, "failed to get lambda alias version")
+	}
+	result.PreviousVersion = previousVersion
+
+	if previousVersion == result.Version {
+		logger.Infof("Lambda alias %s already points at version %s", result.Alias, result.Version)
+		return nil
+	}
+
+	canaryWeight, err := getCanaryWeight()
+	if err != nil {
+		return err
+	}
+
+	if canaryWeight > 0 {
+		err = runCanary(ctx, result, canaryWeight, logger)
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Infof("Promoting lambda alias %s from version %s to version %s", result.Alias, previousVersion, result.Version)
+	err = awsTools.UpdateLambdaAlias(result.Name, result.Alias, result.Version, "", 0)
+	if err != nil {
+		return errors.Wrap(err, "failed to promote lambda alias")
+	}
+
+	return nil
+}
+
+// runCanary routes the canary weight of the alias traffic to the new version, waits for the bake
+// time and reverts the alias to the previous version if the new version reported errors, or if
+// ctx is canceled before the bake time is over.
+func runCanary(ctx context.Context, result *model.LambdaResult, canaryWeight float64, logger appsutils.Logger) error {
+	bakeTime, err := getCanaryBakeTime()
+	if err != nil {
+		return err
+	}
+	maxErrors, err := getCanaryMaxErrors()
+	if err != nil {
+		return err
+	}
+
+	logger.Infof("Routing %.0f%% of lambda alias %s traffic to version %s for %s", canaryWeight*100, result.Alias, result.Version, bakeTime)
+	start := time.Now()
+	err = awsTools.UpdateLambdaAlias(result.Name, result.Alias, result.PreviousVersion, result.Version, canaryWeight)
+	if err != nil {
+		return errors.Wrap(err, "failed to start lambda canary")
+	}
+
+	err = waitBakeTime(ctx, bakeTime)
+	if err == nil {
+		err = checkCanary(result, start, maxErrors)
+		if err == nil {
+			return nil
+		}
+	}
+
+	logger.WithError(err).Errorf("Reverting lambda alias %s to version %s", result.Alias, result.PreviousVersion)
+	revertErr := awsTools.UpdateLambdaAlias(result.Name, result.Alias, result.PreviousVersion, "", 0)
+	if revertErr != nil {
+		return errors.Wrapf(err, "failed to revert lambda alias: %s", revertErr)
+	}
+
+	return err
+}
+
+// waitBakeTime waits for the bake time of the canary, and returns an error if ctx is canceled
+// before it is over.
+func waitBakeTime(ctx context.Context, bakeTime time.Duration) error {
+	timer := time.NewTimer(bakeTime)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "lambda canary stopped before the end of the bake time")
+	case <-timer.C:
+		return nil
+	}
+}
+
+// checkCanary returns an error if the canary version reported more than the allowed errors since
+// the start of the canary.
+func checkCanary(result *model.LambdaResult, start time.Time, maxErrors int) error {
+	errorCount, err := awsTools.GetLambdaVersionErrors(result.Name, result.Alias, result.Version, start)
+	if err != nil {
+		return errors.Wrap(err, "failed to check lambda canary health")
+	}
+	if errorCount > float64(maxErrors) {
+		return errors.Errorf("lambda canary version %s reported %.0f errors, more than the allowed %d", result.Version, errorCount, maxErrors)
+	}
+
+	return nil
+}
+
+func getCanaryWeight() (float64, error) {
+	if os.Getenv("CanaryWeight") == "" {
+		return 0, nil
+	}
+
+	canaryWeight, err := strconv.ParseFloat(os.Getenv("CanaryWeight"), 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse CanaryWeight")
+	}
+	if canaryWeight < 0 || canaryWeight >= 1 {
+		return 0, errors.Errorf("CanaryWeight must be between 0 and 1, got %s", os.Getenv("CanaryWeight"))
+	}
+
+	return canaryWeight, nil
+}
+
+func getCanaryBakeTime() (time.Duration, error) {
+	if os.Getenv("CanaryBakeTime") == "" {
+		return defaultCanaryBakeTime, nil
+	}
+
+	bakeTime, err := time.ParseDuration(os.Getenv("CanaryBakeTime"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse CanaryBakeTime")
+	}
+
+	return bakeTime, nil
+}
+
+func getCanaryMaxErrors() (int, error) {
+	if os.Getenv("CanaryMaxErrors") == "" {
+		return 0, nil
+	}
+
+	maxErrors, err := strconv.Atoi(os.Getenv("CanaryMaxErrors"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse CanaryMaxErrors")
+	}
+
+	return maxErrors, nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+)
+
+func TestAliasedManifest(t *testing.T) {
+	manifest := &appsmodel.Manifest{
+		AppID:   "com.mattermost.hello-world",
+		Version: "1.0.0",
+		Deploy: appsmodel.Deploy{
+			AWSLambda: &appsmodel.AWSLambda{
+				Functions: []appsmodel.AWSLambdaFunction{
+					{Path: "/", Name: "hello", Handler: "hello", Runtime: "go1.x"},
+				},
+			},
+		},
+	}
+
+	aliased, err := aliasedManifest(manifest, "live")
+	require.NoError(t, err)
+	require.Len(t, aliased.AWSLambda.Functions, 1)
+	assert.Equal(t, "hello:live", aliased.AWSLambda.Functions[0].Name)
+	assert.Equal(t, apps.LambdaName(manifest.AppID, manifest.Version, "hello")+":live", apps.LambdaName(aliased.AppID, aliased.Version, aliased.AWSLambda.Functions[0].Name))
+	assert.Equal(t, "hello", manifest.AWSLambda.Functions[0].Name, "the bundle manifest is left untouched")
+
+	t.Run("name too long", func(t *testing.T) {
+		manifest.AWSLambda.Functions[0].Name = strings.Repeat("a", 30)
+		_, err := aliasedManifest(manifest, "live")
+		assert.Error(t, err)
+	})
+}
+
+func TestWaitBakeTime(t *testing.T) {
+	require.NoError(t, waitBakeTime(context.Background(), time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := waitBakeTime(ctx, time.Hour)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), 10*time.Second, "the bake time is cut short once the context is canceled")
+}
@@ -0,0 +1,520 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	exechelper "github.com/mattermost/mattermost-apps/internal/tools/exechelper"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// runType is the operation performed by a run requested through the API.
+type runType string
+
+const (
+	runTypeDeploy   runType = "deploy"
+	runTypePlan     runType = "plan"
+	runTypeUndeploy runType = "undeploy"
+	runTypeRollback runType = "rollback"
+)
+
+// runStatus is the state of a run requested through the API.
+type runStatus string
+
+const (
+	runStatusQueued    runStatus = "queued"
+	runStatusRunning   runStatus = "running"
+	runStatusSucceeded runStatus = "succeeded"
+	runStatusFailed    runStatus = "failed"
+)
+
+// apiRun covers a deployment operation requested through the API.
+type apiRun struct {
+	ID          string        `json:"id"`
+	Type        runType       `json:"type"`
+	Bundle      string        `json:"bundle,omitempty"`
+	AppID       string        `json:"app_id,omitempty"`
+	DryRun      bool          `json:"dry_run"`
+	RequestedBy string        `json:"requested_by,omitempty"`
+	Status      runStatus     `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	Failure     *errorDetails `json:"failure,omitempty"`
+	Result      *bundleReport `json:"result,omitempty"`
+	CreatedAt   time.Time     `json:"created_at"`
+	StartedAt   *time.Time    `json:"started_at,omitempty"`
+	FinishedAt  *time.Time    `json:"finished_at,omitempty"`
+}
+
+// runStore persists the runs requested through the API in a directory, as one JSON file per run
+// next to the logs of the run, so that queued and interrupted runs survive restarts.
+type runStore struct {
+	dir  string
+	lock sync.Mutex
+	runs map[string]*apiRun
+}
+
+// newRunStore loads the runs persisted in the directory. Runs interrupted by a restart are queued
+// again.
+func newRunStore(dir string) (*runStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create run state directory")
+	}
+
+	store := &runStore{dir: dir, runs: map[string]*apiRun{}}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read run state directory")
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".json" {
+			continue
+		}
+
+		content, err := os.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read run %s", entry.Name())
+		}
+		var run apiRun
+		err = json.Unmarshal(content, &run)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse run %s", entry.Name())
+		}
+
+		if run.Status == runStatusRunning {
+			run.Status = runStatusQueued
+			run.StartedAt = nil
+			err = store.save(&run)
+			if err != nil {
+				return nil, err
+			}
+		}
+		store.runs[run.ID] = &run
+	}
+
+	return store, nil
+}
+
+// create queues a new run.
+func (s *runStore) create(run *apiRun) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run.ID = exechelper.NewID()
+	run.Status = runStatusQueued
+	run.CreatedAt = time.Now()
+
+	err := s.save(run)
+	if err != nil {
+		return err
+	}
+	s.runs[run.ID] = run
+
+	return nil
+}
+
+// update applies the change to the run and persists it.
+func (s *runStore) update(id string, change func(run *apiRun)) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return errors.Errorf("run %s not found", id)
+	}
+	change(run)
+
+	return s.save(run)
+}
+
+// get returns a copy of the run, or nil if it does not exist.
+func (s *runStore) get(id string) *apiRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	run, ok := s.runs[id]
+	if !ok {
+		return nil
+	}
+	copied := *run
+
+	return &copied
+}
+
+// list returns a copy of every run, most recent first.
+func (s *runStore) list() []apiRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	runs := make([]apiRun, 0, len(s.runs))
+	for _, run := range s.runs {
+		runs = append(runs, *run)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].CreatedAt.After(runs[j].CreatedAt)
+	})
+
+	return runs
+}
+
+// nextQueued returns a copy of the oldest queued run, or nil if none is queued.
+func (s *runStore) nextQueued() *apiRun {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var next *apiRun
+	for _, run := range s.runs {
+		if run.Status == runStatusQueued && (next == nil || run.CreatedAt.Before(next.CreatedAt)) {
+			next = run
+		}
+	}
+	if next == nil {
+		return nil
+	}
+	copied := *next
+
+	return &copied
+}
+
+// logPath returns the path of the log file of the run.
+func (s *runStore) logPath(id string) string {
+	return path.Join(s.dir, id+".log")
+}
+
+// save writes the run atomically. The lock must be held.
+func (s *runStore) save(run *apiRun) error {
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal run")
+	}
+
+	runPath := path.Join(s.dir, run.ID+".json")
+	err = os.WriteFile(runPath+".tmp", content, 0600)
+	if err != nil {
+		return errors.Wrap(err, "failed to write run")
+	}
+	err = os.Rename(runPath+".tmp", runPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to write run")
+	}
+
+	return nil
+}
+
+// runWorker executes the queued runs one at a time, as deployments share the Terraform template
+// directory.
+type runWorker struct {
+	store   *runStore
+	session *session.Session
+	wake    chan struct{}
+	logger  appsutils.Logger
+
+	// execute performs the run, and is replaced in tests.
+	execute func(ctx context.Context, run *apiRun, logger appsutils.Logger) (*bundleReport, error)
+}
+
+func newRunWorker(store *runStore, session *session.Session, logger appsutils.Logger) *runWorker {
+	worker := &runWorker{
+		store:   store,
+		session: session,
+		wake:    make(chan struct{}, 1),
+		logger:  logger,
+	}
+	worker.execute = worker.executeRun
+
+	return worker
+}
+
+// enqueue persists the run and wakes the worker up.
+func (w *runWorker) enqueue(run *apiRun) error {
+	err := w.store.create(run)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// start executes queued runs until the context is done. The run in progress is completed before
+// it returns.
+func (w *runWorker) start(ctx context.Context) {
+	for {
+		run := w.store.nextQueued()
+		if run == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.wake:
+			}
+			continue
+		}
+
+		w.process(ctx, run)
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (w *runWorker) process(ctx context.Context, run *apiRun) {
+	logger := w.logger.With("run_id", run.ID, "run_type", run.Type)
+
+	logFile, err := os.OpenFile(w.store.logPath(run.ID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to create run log file")
+	} else {
+		defer logFile.Close()
+		logger = newRunLogger(logger, logFile)
+	}
+
+	err = w.store.update(run.ID, func(run *apiRun) {
+		now := time.Now()
+		run.Status = runStatusRunning
+		run.StartedAt = &now
+	})
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to update run")
+	}
+
+	logger.Infof("Starting %s run", run.Type)
+	// Runs are not interrupted by a shutdown, as an interrupted Terraform apply leaves a lock on
+	// the state.
+	result, runErr := w.execute(context.Background(), run, logger)
+	if runErr != nil {
+		logger.WithError(runErr).Errorf("Run failed")
+	} else {
+		logger.Infof("Run succeeded")
+	}
+
+	err = w.store.update(run.ID, func(run *apiRun) {
+		now := time.Now()
+		run.FinishedAt = &now
+		run.Result = result
+		run.Status = runStatusSucceeded
+		if runErr != nil {
+			run.Status = runStatusFailed
+			run.Error = runErr.Error()
+			if deployErr := getDeploymentError(runErr); deployErr != nil {
+				run.Failure = deployErr.details()
+			}
+		}
+	})
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to update run")
+	}
+}
+
+// executeRun performs the run with the deployment pipeline.
+func (w *runWorker) executeRun(ctx context.Context, run *apiRun, logger appsutils.Logger) (*bundleReport, error) {
+	ctx, span := startSpan(ctx, "api run", attribute.String("run_id", run.ID), attribute.String("run_type", string(run.Type)))
+
+	var result *bundleReport
+	var err error
+	switch run.Type {
+	case runTypeDeploy, runTypePlan:
+		result, err = w.deploy(ctx, run.Bundle, run.Type == runTypePlan, logger)
+	case runTypeRollback, runTypeUndeploy:
+		result, err = w.changeApp(ctx, run, logger)
+	default:
+		err = errors.Errorf("unknown run type %s", run.Type)
+	}
+	endSpan(span, err)
+
+	return result, err
+}
+
+// deploy deploys, or plans, the bundle through the same pipeline as the run command.
+func (w *runWorker) deploy(ctx context.Context, bundle string, dryRun bool, logger appsutils.Logger) (*bundleReport, error) {
+	summary := newRunSummary()
+	report := newRunReport()
+	err := deployBundle(ctx, bundle, w.session, dryRun, false, summary, report, logger)
+
+	summary.Duration = time.Since(summary.StartedAt)
+	recordSummaryMetrics(summary)
+	exportErr := exportMetrics()
+	if exportErr != nil {
+		logger.WithError(exportErr).Errorf("Failed to export metrics")
+	}
+
+	return &report.Bundles[0], err
+}
+
+// changeApp rolls back or undeploys the app of the run while holding the lock of the app, so that
+// it is not changed by two runs at once.
+func (w *runWorker) changeApp(ctx context.Context, run *apiRun, logger appsutils.Logger) (*bundleReport, error) {
+	lease, err := claimApp(ctx, run.AppID, w.session)
+	if err != nil {
+		return nil, err
+	}
+	defer releaseLease(lease, logger)
+
+	ctx, cancel := leaseContext(ctx, lease, logger)
+	defer cancel()
+
+	if run.Type == runTypeRollback {
+		return w.rollback(ctx, run.AppID, run.DryRun, logger)
+	}
+
+	return nil, undeployApp(ctx, run.AppID, run.DryRun, logger)
+}
+
+// rollback redeploys the bundle of the app version deployed before the current one.
+func (w *runWorker) rollback(ctx context.Context, appID string, dryRun bool, logger appsutils.Logger) (*bundleReport, error) {
+	record, err := awsTools.GetDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+	if err != nil {
+		return nil, newStageError(categoryStorage, "Get deployment record", errors.Wrap(err, "failed to get the deployment record"))
+	}
+	if record == nil {
+		return nil, newStageError(categoryConfiguration, "Get deployment record", errors.Errorf("app %s is not deployed in %s", appID, os.Getenv("Environment")))
+	}
+	if record.PreviousBundle == "" {
+		return nil, newStageError(categoryConfiguration, "Get deployment record", errors.Errorf("no previous version of app %s to roll back to", appID))
+	}
+
+	logger.Infof("Rolling back app %s from bundle %s to bundle %s", appID, record.Bundle, record.PreviousBundle)
+
+	return w.deploy(ctx, record.PreviousBundle, dryRun, logger)
+}
+
+// undeployApp destroys the lambda functions of the deployed app and removes its deployment
+// record, or plans their destruction in dry run mode.
+func undeployApp(ctx context.Context, appID string, dryRun bool, logger appsutils.Logger) error {
+	record, err := awsTools.GetDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+	if err != nil {
+		return newStageError(categoryStorage, "Get deployment record", errors.Wrap(err, "failed to get the deployment record"))
+	}
+	if record == nil {
+		return newStageError(categoryConfiguration, "Get deployment record", errors.Errorf("app %s is not deployed in %s", appID, os.Getenv("Environment")))
+	}
+
+	// The lambda functions of the retired versions are destroyed too, as nothing prunes them once
+	// the record is deleted.
+	functions := record.Lambdas
+	for _, retired := range record.RetiredVersions {
+		functions = append(functions, retired.Lambdas...)
+	}
+
+	deployment := &model.Deployment{Bundle: record.Bundle, DryRun: dryRun}
+	for _, function := range functions {
+		err = checkLease(ctx, "Destroy lambda")
+		if err != nil {
+			return err
+		}
+
+		lambdaCtx, span := startSpan(ctx, "destroy lambda", attribute.String("lambda", function.Name))
+		err = destroyLambda(lambdaCtx, deployment, function, logger.With("lambda_name", function.Name))
+		endSpan(span, err)
+		if err != nil {
+			return newLambdaStageError(categoryTerraform, "Destroy lambda", function.Name, errors.Wrapf(err, "failed to destroy lambda function %s", function.Name))
+		}
+	}
+	for _, plan := range deployment.Plans {
+		logger.Infof("Planned destruction of lambda function %s: %s", plan.Lambda, plan.Summary)
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	err = checkLease(ctx, "Delete deployment record")
+	if err != nil {
+		return err
+	}
+
+	err = awsTools.DeleteDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+	if err != nil {
+		return newStageError(categoryStorage, "Delete deployment record", errors.Wrap(err, "failed to delete the deployment record"))
+	}
+	logger.Infof("Undeployed app %s", appID)
+
+	return nil
+}
+
+// runLogger logs to the underlying logger, and also writes every entry to the log of a run.
+type runLogger struct {
+	appsutils.Logger
+	w      io.Writer
+	lock   *sync.Mutex
+	fields []string
+}
+
+func newRunLogger(logger appsutils.Logger, w io.Writer) appsutils.Logger {
+	return &runLogger{Logger: logger, w: w, lock: &sync.Mutex{}}
+}
+
+func (l *runLogger) write(level, message string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	line := fmt.Sprintf("%s\t%s\t%s", time.Now().UTC().Format(time.RFC3339), level, message)
+	if len(l.fields) > 0 {
+		line = fmt.Sprintf("%s\t%s", line, strings.Join(l.fields, " "))
+	}
+	fmt.Fprintln(l.w, line)
+}
+
+func (l *runLogger) with(logger appsutils.Logger, args ...interface{}) appsutils.Logger {
+	fields := append([]string{}, l.fields...)
+	for i := 0; i+1 < len(args); i += 2 {
+		fields = append(fields, fmt.Sprintf("%v=%v", args[i], args[i+1]))
+	}
+
+	return &runLogger{Logger: logger, w: l.w, lock: l.lock, fields: fields}
+}
+
+// With returns a logger with the given fields.
+func (l *runLogger) With(args ...interface{}) appsutils.Logger {
+	return l.with(l.Logger.With(args...), args...)
+}
+
+// WithError returns a logger with the given error field.
+func (l *runLogger) WithError(err error) appsutils.Logger {
+	if err == nil {
+		return l
+	}
+
+	return l.with(l.Logger.WithError(err), appsutils.ErrorKey, err.Error())
+}
+
+// Debugf logs a debug message. Debug messages are not written to the run log.
+func (l *runLogger) Debugf(template string, args ...interface{}) {
+	l.Logger.Debugf(template, args...)
+}
+
+// Infof logs an info message.
+func (l *runLogger) Infof(template string, args ...interface{}) {
+	l.Logger.Infof(template, args...)
+	l.write("INFO", fmt.Sprintf(template, args...))
+}
+
+// Warnf logs a warning message.
+func (l *runLogger) Warnf(template string, args ...interface{}) {
+	l.Logger.Warnf(template, args...)
+	l.write("WARN", fmt.Sprintf(template, args...))
+}
+
+// Errorf logs an error message.
+func (l *runLogger) Errorf(template string, args ...interface{}) {
+	l.Logger.Errorf(template, args...)
+	l.write("ERROR", fmt.Sprintf(template, args...))
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// defaultAPIListenAddress is the address the API listens on if APIListenAddress is not set.
+	defaultAPIListenAddress = ":8080"
+	// apiShutdownTimeout bounds the time spent closing the open API connections on shutdown.
+	apiShutdownTimeout = 10 * time.Second
+	// maxRequestBodySize is the maximum size of API request bodies.
+	maxRequestBodySize = 1 << 20
+)
+
+// apiServer serves the deployer HTTP API.
+type apiServer struct {
+	token  string
+	store  *runStore
+	worker *runWorker
+	logger appsutils.Logger
+}
+
+// runRequest is the body of a request to deploy or plan a bundle.
+type runRequest struct {
+	Bundle string `json:"bundle"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// appRequest is the body of a request to undeploy or roll back an app.
+type appRequest struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// runServer serves the deployer HTTP API until it is interrupted. Requests are authenticated
+// with the APIToken bearer token, and the requested runs are queued, executed one at a time and
+// persisted in APIStateDir.
+func runServer(logger appsutils.Logger) {
+	err := initTracing(context.Background())
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to initialize tracing, traces will not be exported")
+	}
+	_, setupSpan := startSpan(context.Background(), "deployer setup")
+
+	session := setup(newRunReport(), setupSpan, logger)
+
+	server, err := newAPIServer(session, logger)
+	if err != nil {
+		err = newStageError(categoryConfiguration, "Start API server", err)
+		logger.WithError(err).Errorf("Failed to start the API server")
+		exitWithSetupError(err, "Mattermost apps deployer failed to start the API server.", newRunReport(), setupSpan, logger)
+	}
+	endSpan(setupSpan, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		server.worker.start(ctx)
+	}()
+
+	listenAddress := os.Getenv("APIListenAddress")
+	if listenAddress == "" {
+		listenAddress = defaultAPIListenAddress
+	}
+	httpServer := &http.Server{
+		Addr:              listenAddress,
+		Handler:           server.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+		defer cancel()
+		shutdownErr := httpServer.Shutdown(shutdownCtx)
+		if shutdownErr != nil {
+			logger.WithError(shutdownErr).Errorf("Failed to shut down the API server")
+		}
+	}()
+
+	logger.Infof("Serving the deployer API on %s", listenAddress)
+	err = httpServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		logger.WithError(err).Errorf("API server failed")
+		stop()
+	}
+
+	logger.Infof("Waiting for the run in progress to complete")
+	wg.Wait()
+	shutdownTracing(logger)
+}
+
+// newAPIServer creates the API server with the runs persisted in APIStateDir, defaulting to the
+// runs directory in TempDir.
+func newAPIServer(session *session.Session, logger appsutils.Logger) (*apiServer, error) {
+	if os.Getenv("APIToken") == "" {
+		return nil, errors.New("APIToken was not set")
+	}
+
+	stateDir := os.Getenv("APIStateDir")
+	if stateDir == "" {
+		stateDir = path.Join(os.Getenv("TempDir"), "runs")
+	}
+	store, err := newRunStore(stateDir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &apiServer{
+		token:  os.Getenv("APIToken"),
+		store:  store,
+		worker: newRunWorker(store, session, logger),
+		logger: logger,
+	}, nil
+}
+
+func (s *apiServer) handler() http.Handler {
+	api := http.NewServeMux()
+	api.HandleFunc("/api/v1/runs", s.handleRuns)
+	api.HandleFunc("/api/v1/runs/", s.handleRun)
+	api.HandleFunc("/api/v1/apps", s.handleApps)
+	api.HandleFunc("/api/v1/apps/", s.handleApp)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.Handle("/api/", s.authenticate(api))
+	// Slash command requests are verified with the slash command token instead of the API token.
+	mux.HandleFunc(commandPath, s.handleCommand)
+
+	return mux
+}
+
+// authenticate rejects requests without the API bearer token.
+func (s *apiServer) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleRuns lists the runs, or queues a deployment, or plan, of a bundle.
+func (s *apiServer) handleRuns(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.store.list())
+	case http.MethodPost:
+		var request runRequest
+		err := decodeJSON(r, &request)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !strings.HasSuffix(request.Bundle, ".zip") || strings.Contains(request.Bundle, "/") {
+			writeAPIError(w, http.StatusBadRequest, errors.New("bundle must be the key of a .zip bundle of the bundle bucket"))
+			return
+		}
+
+		run := &apiRun{Type: runTypeDeploy, Bundle: request.Bundle, DryRun: request.DryRun}
+		if request.DryRun {
+			run.Type = runTypePlan
+		}
+		s.queue(w, run)
+	default:
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleRun returns the state, or the logs, of a run.
+func (s *apiServer) handleRun(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/runs/")
+	logs := strings.HasSuffix(id, "/logs")
+	id = strings.TrimSuffix(id, "/logs")
+
+	run := s.store.get(id)
+	if run == nil {
+		writeAPIError(w, http.StatusNotFound, errors.Errorf("run %s not found", id))
+		return
+	}
+	if !logs {
+		writeJSON(w, http.StatusOK, run)
+		return
+	}
+
+	content, err := os.ReadFile(s.store.logPath(id))
+	if err != nil && !os.IsNotExist(err) {
+		writeAPIError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to read run logs"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
+
+// handleApps lists the apps deployed in the environment given by the environment query
+// parameter, defaulting to the deployer environment.
+func (s *apiServer) handleApps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	environment := r.URL.Query().Get("environment")
+	if environment == "" {
+		environment = os.Getenv("Environment")
+	}
+
+	records, err := awsTools.ListDeploymentRecords(os.Getenv("TerraformStateBucket"), environment)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to list deployed apps"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, records)
+}
+
+// handleApp queues the undeployment, or rollback, of an app.
+func (s *apiServer) handleApp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/v1/apps/"), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		writeAPIError(w, http.StatusNotFound, errors.Errorf("no route for %s", r.URL.Path))
+		return
+	}
+
+	var request appRequest
+	err := decodeJSON(r, &request)
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	run := &apiRun{AppID: parts[0], DryRun: request.DryRun}
+	switch parts[1] {
+	case string(runTypeUndeploy):
+		run.Type = runTypeUndeploy
+	case string(runTypeRollback):
+		run.Type = runTypeRollback
+	default:
+		writeAPIError(w, http.StatusNotFound, errors.Errorf("no route for %s", r.URL.Path))
+		return
+	}
+	s.queue(w, run)
+}
+
+func (s *apiServer) queue(w http.ResponseWriter, run *apiRun) {
+	err := s.worker.enqueue(run)
+	if err != nil {
+		writeAPIError(w, http.StatusInternalServerError, errors.Wrap(err, "failed to queue run"))
+		return
+	}
+	s.logger.With("run_id", run.ID, "run_type", run.Type).Infof("Queued run")
+
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// decodeJSON decodes the request body, which may be empty.
+func decodeJSON(r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxRequestBodySize)).Decode(v)
+	if err != nil && err != io.EOF {
+		return errors.Wrap(err, "invalid request body")
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
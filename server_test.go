@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func newTestAPIServer(t *testing.T, stateDir string) *apiServer {
+	t.Setenv("APIToken", "secret")
+	t.Setenv("APIStateDir", stateDir)
+
+	server, err := newAPIServer(nil, appsutils.NewTestLogger())
+	require.NoError(t, err)
+
+	return server
+}
+
+func apiRequest(t *testing.T, handler http.Handler, method, url, token string, body interface{}) *httptest.ResponseRecorder {
+	var reader io.Reader
+	if body != nil {
+		content, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(content)
+	}
+
+	req := httptest.NewRequest(method, url, reader)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+
+	return recorder
+}
+
+func TestAPIServer(t *testing.T) {
+	t.Run("token required", func(t *testing.T) {
+		t.Setenv("APIToken", "")
+		_, err := newAPIServer(nil, appsutils.NewTestLogger())
+		assert.Error(t, err)
+	})
+
+	server := newTestAPIServer(t, t.TempDir())
+	handler := server.handler()
+
+	t.Run("unauthenticated", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, apiRequest(t, handler, http.MethodGet, "/api/v1/runs", "", nil).Code)
+		assert.Equal(t, http.StatusUnauthorized, apiRequest(t, handler, http.MethodGet, "/api/v1/runs", "wrong", nil).Code)
+		assert.Equal(t, http.StatusOK, apiRequest(t, handler, http.MethodGet, "/healthz", "", nil).Code)
+	})
+
+	t.Run("invalid bundle", func(t *testing.T) {
+		recorder := apiRequest(t, handler, http.MethodPost, "/api/v1/runs", "secret", runRequest{Bundle: "../hello-world.zip"})
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	})
+
+	var planned apiRun
+	t.Run("queue plan", func(t *testing.T) {
+		recorder := apiRequest(t, handler, http.MethodPost, "/api/v1/runs", "secret", runRequest{Bundle: "hello-world.zip", DryRun: true})
+		require.Equal(t, http.StatusAccepted, recorder.Code)
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &planned))
+		assert.Equal(t, runTypePlan, planned.Type)
+		assert.Equal(t, runStatusQueued, planned.Status)
+		assert.NotEmpty(t, planned.ID)
+	})
+
+	t.Run("queue rollback", func(t *testing.T) {
+		recorder := apiRequest(t, handler, http.MethodPost, "/api/v1/apps/hello-world/rollback", "secret", nil)
+		require.Equal(t, http.StatusAccepted, recorder.Code)
+
+		recorder = apiRequest(t, handler, http.MethodPost, "/api/v1/apps/hello-world/unknown", "secret", nil)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+	})
+
+	t.Run("execute runs", func(t *testing.T) {
+		server.worker.execute = func(ctx context.Context, run *apiRun, logger appsutils.Logger) (*bundleReport, error) {
+			logger.With("bundle", run.Bundle).Infof("Executing run")
+			if run.Type == runTypeRollback {
+				return nil, newStageError(categoryConfiguration, "Get deployment record", errors.New("no previous version"))
+			}
+			return &bundleReport{Bundle: run.Bundle, Status: bundleStatusPlanned}, nil
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			server.worker.start(ctx)
+			close(done)
+		}()
+		require.Eventually(t, func() bool { return server.store.nextQueued() == nil }, 5*time.Second, 10*time.Millisecond)
+		require.Eventually(t, func() bool {
+			for _, run := range server.store.list() {
+				if run.FinishedAt == nil {
+					return false
+				}
+			}
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+		cancel()
+		<-done
+
+		var run apiRun
+		recorder := apiRequest(t, handler, http.MethodGet, "/api/v1/runs/"+planned.ID, "secret", nil)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &run))
+		assert.Equal(t, runStatusSucceeded, run.Status)
+		require.NotNil(t, run.Result)
+		assert.Equal(t, bundleStatusPlanned, run.Result.Status)
+
+		recorder = apiRequest(t, handler, http.MethodGet, "/api/v1/runs/"+planned.ID+"/logs", "secret", nil)
+		require.Equal(t, http.StatusOK, recorder.Code)
+		assert.Contains(t, recorder.Body.String(), "Executing run\tbundle=hello-world.zip")
+
+		var runs []apiRun
+		recorder = apiRequest(t, handler, http.MethodGet, "/api/v1/runs", "secret", nil)
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &runs))
+		require.Len(t, runs, 2)
+		assert.Equal(t, runTypeRollback, runs[0].Type)
+		assert.Equal(t, runStatusFailed, runs[0].Status)
+		require.NotNil(t, runs[0].Failure)
+		assert.Equal(t, string(categoryConfiguration), runs[0].Failure.Category)
+	})
+
+	t.Run("unknown run", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, apiRequest(t, handler, http.MethodGet, "/api/v1/runs/unknown", "secret", nil).Code)
+	})
+}
+
+func TestRunStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := newRunStore(dir)
+	require.NoError(t, err)
+
+	queued := &apiRun{Type: runTypeDeploy, Bundle: "hello-world.zip"}
+	require.NoError(t, store.create(queued))
+	interrupted := &apiRun{Type: runTypeDeploy, Bundle: "other.zip"}
+	require.NoError(t, store.create(interrupted))
+	require.NoError(t, store.update(interrupted.ID, func(run *apiRun) {
+		now := time.Now()
+		run.Status = runStatusRunning
+		run.StartedAt = &now
+	}))
+
+	// Runs interrupted by a restart are queued again.
+	store, err = newRunStore(dir)
+	require.NoError(t, err)
+	assert.Equal(t, runStatusQueued, store.get(queued.ID).Status)
+	assert.Equal(t, runStatusQueued, store.get(interrupted.ID).Status)
+	assert.Nil(t, store.get(interrupted.ID).StartedAt)
+	assert.Equal(t, queued.ID, store.nextQueued().ID)
+
+	_, err = os.Stat(store.logPath(queued.ID))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// smokeTestFileName is the name of the optional bundle file with the smoke test payload.
+	smokeTestFileName = "smoke_test.json"
+	// smokeTestPassed is the smoke test status of a lambda that returned a successful response.
+	smokeTestPassed = "passed"
+	// smokeTestFailed is the smoke test status of a lambda that did not return a successful response.
+	smokeTestFailed = "failed"
+)
+
+// smokeTestEnabled returns true if the deployed lambdas should be invoked after deployment.
+func smokeTestEnabled() bool {
+	return os.Getenv("SmokeTest") == "true"
+}
+
+// getSmokeTestPayload returns the payload used to invoke the deployed lambdas. The payload is
+// read from the bundle smoke test file if present, then from SmokeTestPayload, and defaults to a
+// Mattermost Apps ping call.
+func getSmokeTestPayload(bundleName string) ([]byte, error) {
+	payload, err := os.ReadFile(path.Join(os.Getenv("TempDir"), bundleName, smokeTestFileName))
+	if err == nil {
+		return payload, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to read bundle smoke test payload")
+	}
+
+	if os.Getenv("SmokeTestPayload") != "" {
+		return []byte(os.Getenv("SmokeTestPayload")), nil
+	}
+
+	creq := appsmodel.CallRequest{
+		Call: appsmodel.Call{Path: "/ping"},
+	}
+
+	return creq.ToHTTPCallRequestJSON()
+}
+
+// smokeTestLambda invokes the newly published lambda version with the smoke test payload and
+// checks that it returned a successful response. It runs before the lambda alias is moved to the
+// version, so a failed smoke test leaves the live version untouched.
+func smokeTestLambda(result *model.LambdaResult, payload []byte, logger appsutils.Logger) error {
+	logger.Infof("Invoking lambda version %s with the smoke test payload", result.Version)
+
+	err := invokeSmokeTest(result, payload)
+	if err != nil {
+		result.SmokeTest = smokeTestFailed
+		return err
+	}
+
+	result.SmokeTest = smokeTestPassed
+	logger.Infof("Lambda smoke test passed")
+	return nil
+}
+
+func invokeSmokeTest(result *model.LambdaResult, payload []byte) error {
+	response, functionError, err := awsTools.InvokeLambda(result.Name, result.Version, payload)
+	if err != nil {
+		return errors.Wrap(err, "failed to invoke lambda")
+	}
+	if functionError != "" {
+		return errors.Errorf("lambda smoke test returned function error %s: %s", functionError, string(response))
+	}
+
+	_, err = appsmodel.HTTPCallResponseFromJSON(response)
+	if err != nil {
+		return errors.Wrap(err, "lambda smoke test did not return a successful response")
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	model "github.com/mattermost/mattermost-apps/model"
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// defaultStaticRetention is the time the static assets, manifest and lambda functions of a retired
+// app version are kept for if StaticRetention is not set.
+const defaultStaticRetention = 7 * 24 * time.Hour
+
+// staticRetention returns the configured time the static assets and manifest of an app version
+// are kept for once a later version was deployed.
+func staticRetention() (time.Duration, error) {
+	if os.Getenv("StaticRetention") == "" {
+		return defaultStaticRetention, nil
+	}
+
+	retention, err := time.ParseDuration(os.Getenv("StaticRetention"))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to parse StaticRetention")
+	}
+
+	return retention, nil
+}
+
+// retireVersion returns the retired versions of the app once the given version is deployed. The
+// version of the previous record is retired if it differs, and a retired version which is deployed
+// again is not retired anymore.
+func retireVersion(record *model.DeploymentRecord, version string, deployedAt time.Time) []model.RetiredVersion {
+	var retired []model.RetiredVersion
+	for _, retiredVersion := range record.RetiredVersions {
+		if retiredVersion.Version != version && retiredVersion.Version != record.Version {
+			retired = append(retired, retiredVersion)
+		}
+	}
+
+	if record.Version != version {
+		retired = append(retired, model.RetiredVersion{
+			Version:   record.Version,
+			Bundle:    record.Bundle,
+			RetiredAt: deployedAt,
+			Lambdas:   record.Lambdas,
+		})
+	}
+
+	return retired
+}
+
+// expiredVersions splits the retired versions of the record into the ones retired for longer than
+// the retention period, and the ones which are kept.
+func expiredVersions(record *model.DeploymentRecord, retention time.Duration, now time.Time) (expired, kept []model.RetiredVersion) {
+	for _, retired := range record.RetiredVersions {
+		if retired.Version != record.Version && now.Sub(retired.RetiredAt) >= retention {
+			expired = append(expired, retired)
+		} else {
+			kept = append(kept, retired)
+		}
+	}
+
+	return expired, kept
+}
+
+// pruneRetiredVersions removes the static assets and manifests of the app versions retired for
+// longer than StaticRetention, defaulting to defaultStaticRetention, from the static bucket, then
+// destroys their lambda functions.
+func pruneRetiredVersions(ctx context.Context, appID string, logger appsutils.Logger) error {
+	retention, err := staticRetention()
+	if err != nil {
+		return err
+	}
+
+	record, err := awsTools.GetDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+	if err != nil {
+		return errors.Wrap(err, "failed to get the deployment record")
+	}
+	if record == nil {
+		return nil
+	}
+
+	expired, kept := expiredVersions(record, retention, time.Now())
+	if len(expired) == 0 {
+		return nil
+	}
+
+	live := make(map[string]bool, len(record.Lambdas))
+	for _, function := range record.Lambdas {
+		live[function.Name] = true
+	}
+	for _, retired := range expired {
+		err = removeStaticVersion(appID, retired.Version, logger)
+		if err != nil {
+			return err
+		}
+
+		for _, function := range retired.Lambdas {
+			if live[function.Name] {
+				continue
+			}
+			err = destroyLambda(ctx, &model.Deployment{}, function, logger.With("lambda_name", function.Name))
+			if err != nil {
+				return errors.Wrapf(err, "failed to destroy lambda function %s of version %s", function.Name, retired.Version)
+			}
+		}
+	}
+
+	record.RetiredVersions = kept
+	err = awsTools.PutDeploymentRecord(os.Getenv("TerraformStateBucket"), record)
+	if err != nil {
+		return errors.Wrap(err, "failed to store the deployment record")
+	}
+
+	return nil
+}
+
+// removeStaticVersion removes the manifest, then the static assets, of an app version from the
+// static bucket.
+func removeStaticVersion(appID, version string, logger appsutils.Logger) error {
+	keys, err := awsTools.ListStaticFiles(apps.S3StaticName(appsmodel.AppID(appID), appsmodel.AppVersion(version), ""))
+	if err != nil {
+		return errors.Wrapf(err, "failed to list the static assets of version %s", version)
+	}
+
+	manifestKey := apps.S3ManifestName(appsmodel.AppID(appID), appsmodel.AppVersion(version))
+	err = awsTools.DeleteStaticFiles(append([]string{manifestKey}, keys...), logger)
+	if err != nil {
+		return errors.Wrapf(err, "failed to remove the static assets of version %s", version)
+	}
+	logger.Infof("Removed the manifest and %d static assets of retired version %s", len(keys), version)
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+func TestRetireVersion(t *testing.T) {
+	deployedAt := time.Date(2022, 6, 1, 0, 0, 0, 0, time.UTC)
+	record := &model.DeploymentRecord{
+		Version: "1.1.0",
+		Bundle:  "hello-world_1.1.0.zip",
+		Lambdas: []model.Function{{Name: "hello-world_1-1-0_hello", ManifestName: "hello"}},
+		RetiredVersions: []model.RetiredVersion{
+			{Version: "1.0.0", Bundle: "hello-world_1.0.0.zip", RetiredAt: deployedAt.Add(-time.Hour)},
+		},
+	}
+
+	t.Run("new version", func(t *testing.T) {
+		retired := retireVersion(record, "1.2.0", deployedAt)
+		require.Len(t, retired, 2)
+		assert.Equal(t, "1.0.0", retired[0].Version)
+		assert.Equal(t, model.RetiredVersion{Version: "1.1.0", Bundle: "hello-world_1.1.0.zip", RetiredAt: deployedAt, Lambdas: record.Lambdas}, retired[1])
+	})
+
+	t.Run("same version", func(t *testing.T) {
+		retired := retireVersion(record, "1.1.0", deployedAt)
+		require.Len(t, retired, 1)
+		assert.Equal(t, "1.0.0", retired[0].Version)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		retired := retireVersion(record, "1.0.0", deployedAt)
+		require.Len(t, retired, 1)
+		assert.Equal(t, "1.1.0", retired[0].Version)
+	})
+}
+
+func TestExpiredVersions(t *testing.T) {
+	now := time.Date(2022, 6, 10, 0, 0, 0, 0, time.UTC)
+	record := &model.DeploymentRecord{
+		Version: "1.2.0",
+		RetiredVersions: []model.RetiredVersion{
+			{Version: "1.0.0", RetiredAt: now.Add(-8 * 24 * time.Hour)},
+			{Version: "1.1.0", RetiredAt: now.Add(-time.Hour)},
+		},
+	}
+
+	expired, kept := expiredVersions(record, defaultStaticRetention, now)
+	require.Len(t, expired, 1)
+	assert.Equal(t, "1.0.0", expired[0].Version)
+	require.Len(t, kept, 1)
+	assert.Equal(t, "1.1.0", kept[0].Version)
+
+	expired, kept = expiredVersions(record, 0, now)
+	assert.Len(t, expired, 2)
+	assert.Empty(t, kept)
+}
+
+func TestStaticRetention(t *testing.T) {
+	retention, err := staticRetention()
+	require.NoError(t, err)
+	assert.Equal(t, defaultStaticRetention, retention)
+
+	t.Setenv("StaticRetention", "48h")
+	retention, err = staticRetention()
+	require.NoError(t, err)
+	assert.Equal(t, 48*time.Hour, retention)
+
+	t.Setenv("StaticRetention", "two days")
+	_, err = staticRetention()
+	assert.Error(t, err)
+}
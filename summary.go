@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+// errorExcerptLength is the maximum length of the error excerpts in the run summary.
+const errorExcerptLength = 300
+
+// The reasons bundles are skipped for.
+const (
+	skipReasonDeployed = "already_deployed"
+	skipReasonClaimed  = "claimed"
+)
+
+// skipReasonLabels are the labels of the skipped bundles in the run summary, per reason.
+var skipReasonLabels = map[string]string{
+	skipReasonDeployed: "Already Deployed",
+	skipReasonClaimed:  "Claimed By Another Deployer",
+}
+
+// runSummary covers the outcome of every bundle considered during a deployer run.
+type runSummary struct {
+	StartedAt time.Time       `json:"started_at"`
+	Duration  time.Duration   `json:"duration"`
+	Deployed  []bundleSummary `json:"deployed"`
+	Planned   []bundleSummary `json:"planned"`
+	Skipped   []skippedBundle `json:"skipped"`
+	Failed    []bundleSummary `json:"failed"`
+}
+
+// bundleSummary covers the outcome of a single bundle deployment.
+type bundleSummary struct {
+	Bundle   string        `json:"bundle"`
+	AppID    string        `json:"app_id,omitempty"`
+	Version  string        `json:"version,omitempty"`
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// skippedBundle covers a bundle which was not deployed, and the reason it was skipped for.
+type skippedBundle struct {
+	Bundle string `json:"bundle"`
+	Reason string `json:"reason"`
+}
+
+// skippedGroup covers the skipped bundles of a reason, formatted for the run summary.
+type skippedGroup struct {
+	Title string
+	Value string
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{StartedAt: time.Now()}
+}
+
+// addResult records the outcome of a bundle deployment.
+func (s *runSummary) addResult(bundle string, deployment *model.Deployment, duration time.Duration, err error) {
+	result := bundleSummary{
+		Bundle:   bundle,
+		Duration: duration,
+	}
+	if deployment != nil && deployment.Manifest != nil {
+		result.AppID = string(deployment.Manifest.AppID)
+		result.Version = string(deployment.Manifest.Version)
+	}
+
+	switch {
+	case err != nil:
+		result.Error = errorExcerpt(err)
+		s.Failed = append(s.Failed, result)
+	case !deployment.DryRun:
+		s.Deployed = append(s.Deployed, result)
+	default:
+		s.Planned = append(s.Planned, result)
+	}
+}
+
+// addSkipped records the bundles skipped for the given reason.
+func (s *runSummary) addSkipped(bundles []string, reason string) {
+	for _, bundle := range bundles {
+		s.Skipped = append(s.Skipped, skippedBundle{Bundle: bundle, Reason: reason})
+	}
+}
+
+// isEmpty returns true if no bundle was deployed, planned or failed during the run.
+func (s *runSummary) isEmpty() bool {
+	return len(s.Deployed) == 0 && len(s.Planned) == 0 && len(s.Failed) == 0
+}
+
+// exitCode returns the exit code of a run which got past the setup.
+func (s *runSummary) exitCode() int {
+	if len(s.Failed) > 0 {
+		return exitCodeBundlesFailed
+	}
+
+	return exitCodeSuccess
+}
+
+// errorExcerpt returns the error message truncated to errorExcerptLength.
+func errorExcerpt(err error) string {
+	message := err.Error()
+	if len(message) <= errorExcerptLength {
+		return message
+	}
+
+	return message[:errorExcerptLength] + "…"
+}
+
+// formatBundleSummaries formats the bundle outcomes as a markdown list.
+func formatBundleSummaries(bundles []bundleSummary) string {
+	var lines []string
+	for _, bundle := range bundles {
+		line := fmt.Sprintf("- `%s`", bundle.Bundle)
+		if bundle.AppID != "" {
+			line = fmt.Sprintf("%s (%s %s)", line, bundle.AppID, bundle.Version)
+		}
+		line = fmt.Sprintf("%s in %s", line, bundle.Duration.Round(time.Second))
+		if bundle.Error != "" {
+			line = fmt.Sprintf("%s: %s", line, bundle.Error)
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// formatSkippedBundles groups the skipped bundles by reason, with the label of the reason and the
+// bundles as a markdown list.
+func formatSkippedBundles(skipped []skippedBundle) []skippedGroup {
+	var groups []skippedGroup
+	for _, reason := range []string{skipReasonDeployed, skipReasonClaimed} {
+		var lines []string
+		for _, bundle := range skipped {
+			if bundle.Reason == reason {
+				lines = append(lines, fmt.Sprintf("- `%s`", bundle.Bundle))
+			}
+		}
+		if len(lines) > 0 {
+			groups = append(groups, skippedGroup{
+				Title: fmt.Sprintf("Skipped, %s (%d)", skipReasonLabels[reason], len(lines)),
+				Value: strings.Join(lines, "\n"),
+			})
+		}
+	}
+
+	return groups
+}
+
+// perBundleNotifications returns true unless PerBundleNotifications is set to false, in which
+// case only the run summary is sent.
+func perBundleNotifications() bool {
+	return os.Getenv("PerBundleNotifications") != "false"
+}
+
+// sendSummaryNotification sends the digest of the run.
+func sendSummaryNotification(summary *runSummary) error {
+	data := newTemplateData(eventSummary)
+	data.Summary = summary
+
+	payload, err := renderNotification(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to render summary notification")
+	}
+
+	err = notify(&notification{
+		Event:       eventSummary,
+		Environment: data.Environment,
+		Summary:     summary,
+		Payload:     payload,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to send summary notification")
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	mmmodel "github.com/mattermost/mattermost-server/v5/model"
+	"github.com/pkg/errors"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+const (
+	// defaultNotificationUsername is the username of notifications if NotificationUsername is not set.
+	defaultNotificationUsername = "Mattermost Apps Deployer"
+	// defaultNotificationIconURL is the icon of notifications if NotificationIconURL is not set.
+	defaultNotificationIconURL = "https://cdn-images-1.medium.com/max/1200/1*9860tn6_CPEPnBxF1wIpmw@2x.jpeg"
+)
+
+// defaultTemplates holds the built-in notification templates, named after their event.
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// templateData covers the data notification templates are rendered with. Templates only get these
+// fields, and not the process environment, which holds the deployer credentials.
+type templateData struct {
+	Event       notificationEvent
+	Environment string
+	Username    string
+	IconURL     string
+	Message     string
+	Error       string
+	Deployment  *model.Deployment
+	Summary     *runSummary
+	Failure     *errorDetails
+	Colors      templateColors
+}
+
+// templateColors are the attachment colors of the notifications.
+type templateColors struct {
+	Deployment string
+	Plan       string
+	Error      string
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"join":             strings.Join,
+	"duration":         func(d time.Duration) string { return d.Round(time.Second).String() },
+	"providerVersions": formatProviderVersions,
+	"lambdaResults":    formatLambdaResults,
+	"bundleSummaries":  formatBundleSummaries,
+	"skippedBundles":   formatSkippedBundles,
+	"planSummaries":    formatPlanSummaries,
+	"planOutputs":      formatPlanOutputs,
+}
+
+// newTemplateData returns the template data of a notification, with the username and icon
+// overridable through NotificationUsername and NotificationIconURL.
+func newTemplateData(event notificationEvent) *templateData {
+	data := &templateData{
+		Event:       event,
+		Environment: os.Getenv("Environment"),
+		Username:    os.Getenv("NotificationUsername"),
+		IconURL:     os.Getenv("NotificationIconURL"),
+		Colors: templateColors{
+			Deployment: colorDeployment,
+			Plan:       colorPlan,
+			Error:      colorError,
+		},
+	}
+	if data.Username == "" {
+		data.Username = defaultNotificationUsername
+	}
+	if data.IconURL == "" {
+		data.IconURL = defaultNotificationIconURL
+	}
+
+	return data
+}
+
+// renderNotification renders the notification payload of the event. The template is read from
+// <event>.tmpl in NotificationTemplateDir if present, falling back to the built-in template, and
+// must render a JSON Mattermost command response.
+func renderNotification(data *templateData) (mmmodel.CommandResponse, error) {
+	var payload mmmodel.CommandResponse
+
+	name := string(data.Event) + ".tmpl"
+	content, err := readTemplate(name)
+	if err != nil {
+		return payload, err
+	}
+
+	tmpl, err := template.New(name).Funcs(templateFuncs).Parse(string(content))
+	if err != nil {
+		return payload, errors.Wrapf(err, "failed to parse notification template %s", name)
+	}
+
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, data)
+	if err != nil {
+		return payload, errors.Wrapf(err, "failed to render notification template %s", name)
+	}
+
+	err = json.Unmarshal(rendered.Bytes(), &payload)
+	if err != nil {
+		return payload, errors.Wrapf(err, "notification template %s did not render valid JSON", name)
+	}
+
+	return payload, nil
+}
+
+func readTemplate(name string) ([]byte, error) {
+	if os.Getenv("NotificationTemplateDir") != "" {
+		content, err := os.ReadFile(path.Join(os.Getenv("NotificationTemplateDir"), name))
+		if err == nil {
+			return content, nil
+		}
+		if !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to read notification template %s", name)
+		}
+	}
+
+	content, err := defaultTemplates.ReadFile(path.Join("templates", name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "no notification template %s", name)
+	}
+
+	return content, nil
+}
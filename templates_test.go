@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	appsmodel "github.com/mattermost/mattermost-plugin-apps/apps"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	model "github.com/mattermost/mattermost-apps/model"
+)
+
+func TestRenderNotification(t *testing.T) {
+	os.Setenv("Environment", "test")
+	defer os.Unsetenv("Environment")
+
+	t.Run("deployment", func(t *testing.T) {
+		data := newTemplateData(eventDeployment)
+		data.Deployment = &model.Deployment{
+			Bundle: "hello-world.zip",
+			Manifest: &appsmodel.Manifest{
+				AppID:       "hello-world",
+				Version:     "v1.0.0",
+				DisplayName: "Hello World",
+				HomepageURL: "https://example.com",
+			},
+			TerraformVersion: "1.1.8",
+			Lambdas:          []model.LambdaResult{{Name: "hello-world_v1-0-0_go-function", Version: "3", Alias: "live"}},
+			Duration:         90 * time.Second,
+		}
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		assert.Equal(t, defaultNotificationUsername, payload.Username)
+		require.Len(t, payload.Attachments, 1)
+
+		attachment := payload.Attachments[0]
+		assert.Equal(t, colorDeployment, attachment.Color)
+		var titles []string
+		for _, field := range attachment.Fields {
+			titles = append(titles, field.Title)
+		}
+		assert.Equal(t, []string{"Name", "ID", "Version", "Bundle", "Terraform", "Lambda Functions", "Duration", "Environment"}, titles)
+		assert.Equal(t, "[Hello World](https://example.com)", attachment.Fields[0].Value)
+		assert.Equal(t, "1m30s", attachment.Fields[6].Value)
+		assert.Equal(t, "test", attachment.Fields[7].Value)
+	})
+
+	t.Run("plan", func(t *testing.T) {
+		data := newTemplateData(eventPlan)
+		data.Deployment = &model.Deployment{
+			Bundle:   "hello-world.zip",
+			Manifest: &appsmodel.Manifest{AppID: "hello-world", Version: "v1.0.0"},
+			DryRun:   true,
+			Plans: []model.PlanResult{{
+				Lambda:    "hello-world_v1-0-0_go-function",
+				Summary:   "1 to add, 0 to change, 0 to destroy",
+				Resources: []string{"module.apps_deployment.aws_lambda_function.lambda_function (create)"},
+				Output:    "Plan: 1 to add, 0 to change, 0 to destroy.",
+			}},
+		}
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		require.Len(t, payload.Attachments, 2)
+		assert.Contains(t, payload.Attachments[0].Title, "DRY RUN")
+		assert.Equal(t, colorPlan, payload.Attachments[0].Color)
+		assert.Equal(t, "Planned Changes", payload.Attachments[0].Fields[4].Title)
+		assert.Equal(t, "- `hello-world_v1-0-0_go-function`: 1 to add, 0 to change, 0 to destroy\n  - `module.apps_deployment.aws_lambda_function.lambda_function (create)`", payload.Attachments[0].Fields[4].Value)
+		assert.Equal(t, "**hello-world_v1-0-0_go-function**\n```\nPlan: 1 to add, 0 to change, 0 to destroy.\n```", payload.Attachments[1].Text)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		data := newTemplateData(eventError)
+		data.Message = "Mattermost apps deployment failed."
+		data.Error = `failed to "deploy"`
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		require.Len(t, payload.Attachments, 1)
+		assert.Equal(t, colorError, payload.Attachments[0].Color)
+		assert.Equal(t, `failed to "deploy"`, payload.Attachments[0].Fields[1].Value)
+	})
+
+	t.Run("error with failure details", func(t *testing.T) {
+		data := newTemplateData(eventError)
+		data.Message = "Mattermost apps deployment failed."
+		data.Error = "failed to run Terraform apply"
+		data.Failure = &errorDetails{
+			Category: string(categoryTerraform),
+			Stage:    "Terraform apply",
+			Bundle:   "hello-world.zip",
+			Lambda:   "hello-world_v1-0-0_go-function",
+			Hint:     categoryHints[categoryTerraform],
+			Stderr:   "Error: creating Lambda Function",
+		}
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		require.Len(t, payload.Attachments, 1)
+		fields := payload.Attachments[0].Fields
+		require.Len(t, fields, 9)
+		assert.Equal(t, "terraform", fields[2].Value)
+		assert.Equal(t, "Terraform apply", fields[3].Value)
+		assert.Equal(t, "hello-world.zip", fields[4].Value)
+		assert.Equal(t, "hello-world_v1-0-0_go-function", fields[5].Value)
+		assert.Equal(t, "```\nError: creating Lambda Function\n```", fields[7].Value)
+	})
+
+	t.Run("summary", func(t *testing.T) {
+		data := newTemplateData(eventSummary)
+		data.Summary = &runSummary{
+			Deployed: []bundleSummary{{Bundle: "hello-world.zip", AppID: "hello-world", Version: "v1.0.0", Duration: time.Minute}},
+			Failed:   []bundleSummary{{Bundle: "broken.zip", Duration: time.Second, Error: "failed to unzip the bundle"}},
+			Skipped:  []skippedBundle{{Bundle: "old.zip", Reason: skipReasonDeployed}, {Bundle: "busy.zip", Reason: skipReasonClaimed}},
+			Duration: 2 * time.Minute,
+		}
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		require.Len(t, payload.Attachments, 1)
+
+		attachment := payload.Attachments[0]
+		assert.Equal(t, colorError, attachment.Color)
+		require.Len(t, attachment.Fields, 6)
+		assert.Equal(t, "Deployed (1)", attachment.Fields[0].Title)
+		assert.Equal(t, "- `hello-world.zip` (hello-world v1.0.0) in 1m0s", attachment.Fields[0].Value)
+		assert.Equal(t, "Failed (1)", attachment.Fields[1].Title)
+		assert.Equal(t, "- `broken.zip` in 1s: failed to unzip the bundle", attachment.Fields[1].Value)
+		assert.Equal(t, "Skipped, Already Deployed (1)", attachment.Fields[2].Title)
+		assert.Equal(t, "- `old.zip`", attachment.Fields[2].Value)
+		assert.Equal(t, "Skipped, Claimed By Another Deployer (1)", attachment.Fields[3].Title)
+		assert.Equal(t, "- `busy.zip`", attachment.Fields[3].Value)
+	})
+
+	t.Run("custom template", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `{"username": "Deployer", "text": {{ json (printf "%s failed in %s" .Message .Environment) }}}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "error.tmpl"), []byte(content), 0600))
+		os.Setenv("NotificationTemplateDir", dir)
+		defer os.Unsetenv("NotificationTemplateDir")
+
+		data := newTemplateData(eventError)
+		data.Message = "Deployment"
+
+		payload, err := renderNotification(data)
+		require.NoError(t, err)
+		assert.Equal(t, "Deployer", payload.Username)
+		assert.Equal(t, "Deployment failed in test", payload.Text)
+	})
+
+	t.Run("environment not exposed", func(t *testing.T) {
+		dir := t.TempDir()
+		content := `{"text": {{ json (env "APIToken") }}}`
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "error.tmpl"), []byte(content), 0600))
+		os.Setenv("NotificationTemplateDir", dir)
+		defer os.Unsetenv("NotificationTemplateDir")
+
+		_, err := renderNotification(newTemplateData(eventError))
+		assert.Error(t, err)
+	})
+}
@@ -23,6 +23,7 @@ module "apps_deployment" {
   runtime                          = var.runtime
   environment                      = var.environment
   private_subnet_ids               = var.private_subnet_ids
+  alias_name                       = var.alias_name
 
   tags = {
     Owner       = "cloud-team"
@@ -0,0 +1,15 @@
+output "lambda_arn" {
+  value = module.apps_deployment.lambda_arn
+}
+
+output "lambda_version" {
+  value = module.apps_deployment.lambda_version
+}
+
+output "lambda_last_modified" {
+  value = module.apps_deployment.lambda_last_modified
+}
+
+output "lambda_alias_arn" {
+  value = module.apps_deployment.lambda_alias_arn
+}
@@ -37,3 +37,8 @@ variable "private_subnet_ids" {
   type    = list(string)
   default = [""]
 }
+
+variable "alias_name" {
+  default = "live"
+  type    = string
+}
@@ -13,10 +13,13 @@ data "terraform_remote_state" "generic" {
 resource "aws_lambda_function" "lambda_function" {
   function_name = var.lambda_name
   role          = data.terraform_remote_state.generic.outputs.mattermost_apps_lambda_role.arn
+  # Destroys pass a placeholder lambda_file, as the zip file of a function dropped from the app is
+  # not part of the deployed bundle, so the file must only be read on create and update.
   filename      = "../../../tmp/${var.bundle_name}/${var.lambda_file}"
   handler       = var.handler
   timeout       = 120
   runtime       = var.runtime
+  publish       = true
 
   vpc_config {
     subnet_ids         = flatten(var.private_subnet_ids)
@@ -26,3 +29,16 @@ resource "aws_lambda_function" "lambda_function" {
   tags = var.tags
 
 }
+
+resource "aws_lambda_alias" "lambda_alias" {
+  name             = var.alias_name
+  function_name    = aws_lambda_function.lambda_function.function_name
+  function_version = aws_lambda_function.lambda_function.version
+
+  # The published manifest invokes the function through the alias, and the deployer moves the alias
+  # to newly published versions, optionally through a weighted canary rollout, so Terraform only
+  # sets the initial version.
+  lifecycle {
+    ignore_changes = [function_version, routing_config]
+  }
+}
@@ -0,0 +1,15 @@
+output "lambda_arn" {
+  value = aws_lambda_function.lambda_function.arn
+}
+
+output "lambda_version" {
+  value = aws_lambda_function.lambda_function.version
+}
+
+output "lambda_last_modified" {
+  value = aws_lambda_function.lambda_function.last_modified
+}
+
+output "lambda_alias_arn" {
+  value = aws_lambda_alias.lambda_alias.arn
+}
@@ -13,3 +13,5 @@ variable "bundle_name" {}
 variable "environment" {}
 
 variable "private_subnet_ids" {}
+
+variable "alias_name" {}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+const (
+	// tracingExporterOTLP exports traces over OTLP/HTTP, configured through the standard
+	// OTEL_EXPORTER_OTLP_* environment variables.
+	tracingExporterOTLP = "otlp"
+	// tracingExporterFile writes traces as JSON to TracingFile, defaulting to traces.json in TempDir.
+	tracingExporterFile = "file"
+
+	// tracingShutdownTimeout bounds the time spent flushing the traces at the end of the run.
+	tracingShutdownTimeout = 10 * time.Second
+)
+
+var tracer = otel.Tracer("github.com/mattermost/mattermost-apps")
+
+var (
+	tracerProvider *sdktrace.TracerProvider
+	traceFile      io.Closer
+)
+
+// initTracing sets up the exporter selected by TracingExporter. Tracing is disabled if it is not
+// set, in which case every span is a no-op.
+func initTracing(ctx context.Context) error {
+	var exporter sdktrace.SpanExporter
+	switch os.Getenv("TracingExporter") {
+	case "":
+		return nil
+	case tracingExporterOTLP:
+		otlpExporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to create OTLP trace exporter")
+		}
+		exporter = otlpExporter
+	case tracingExporterFile:
+		tracePath := os.Getenv("TracingFile")
+		if tracePath == "" {
+			tracePath = path.Join(os.Getenv("TempDir"), "traces.json")
+		}
+		file, err := os.OpenFile(tracePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return errors.Wrap(err, "failed to open trace file")
+		}
+		fileExporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return errors.Wrap(err, "failed to create file trace exporter")
+		}
+		exporter = fileExporter
+		traceFile = file
+	default:
+		return errors.Errorf("unknown tracing exporter %q", os.Getenv("TracingExporter"))
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("mattermost-apps-deployer"),
+			semconv.DeploymentEnvironmentKey.String(os.Getenv("Environment")),
+		)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+
+	return nil
+}
+
+// shutdownTracing flushes the pending spans. It must be called before the deployer exits.
+func shutdownTracing(logger appsutils.Logger) {
+	if tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+
+	err := tracerProvider.Shutdown(ctx)
+	if err != nil {
+		logger.WithError(err).Errorf("Failed to flush traces")
+	}
+	if traceFile != nil {
+		traceFile.Close()
+	}
+}
+
+// startSpan starts a span as a child of the span in ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span, recording the error and its category if the step failed.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, errorExcerpt(err))
+		if deployErr := getDeploymentError(err); deployErr != nil {
+			span.SetAttributes(attribute.String("error.category", string(deployErr.Category)))
+		}
+	}
+	span.End()
+}
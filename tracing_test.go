@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+
+	exechelper "github.com/mattermost/mattermost-apps/internal/tools/exechelper"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestTracing(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		t.Setenv("TracingExporter", "")
+		require.NoError(t, initTracing(context.Background()))
+		assert.Nil(t, tracerProvider)
+	})
+
+	t.Run("unknown exporter", func(t *testing.T) {
+		t.Setenv("TracingExporter", "zipkin")
+		assert.Error(t, initTracing(context.Background()))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		traceFilePath := filepath.Join(t.TempDir(), "traces.json")
+		t.Setenv("TracingExporter", tracingExporterFile)
+		t.Setenv("TracingFile", traceFilePath)
+		previous := otel.GetTracerProvider()
+		defer func() {
+			otel.SetTracerProvider(previous)
+			tracerProvider = nil
+			traceFile = nil
+		}()
+
+		logger := appsutils.NewTestLogger()
+		require.NoError(t, initTracing(context.Background()))
+
+		ctx, span := startSpan(context.Background(), "deploy bundle")
+		_, _, err := exechelper.RunContext(ctx, exec.Command("true"), logger, nil)
+		require.NoError(t, err)
+		endSpan(span, newStageError(categoryStorage, "Download bundle", errors.New("failed to get s3 object")))
+		shutdownTracing(logger)
+
+		content, err := os.ReadFile(traceFilePath)
+		require.NoError(t, err)
+
+		spans := map[string]map[string]interface{}{}
+		decoder := json.NewDecoder(strings.NewReader(string(content)))
+		for decoder.More() {
+			var exported map[string]interface{}
+			require.NoError(t, decoder.Decode(&exported))
+			spans[exported["Name"].(string)] = exported
+		}
+		require.Contains(t, spans, "deploy bundle")
+		require.Contains(t, spans, "exec true")
+
+		bundleSpan := spans["deploy bundle"]
+		execSpan := spans["exec true"]
+		assert.Equal(t, bundleSpan["SpanContext"].(map[string]interface{})["SpanID"], execSpan["Parent"].(map[string]interface{})["SpanID"])
+		assert.Equal(t, "Error", bundleSpan["Status"].(map[string]interface{})["Code"])
+		assert.Contains(t, string(content), `"Key":"run"`)
+		assert.Contains(t, string(content), `"Key":"error.category"`)
+
+		assert.True(t, trace.SpanContextFromContext(ctx).IsValid())
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	awsTools "github.com/mattermost/mattermost-apps/internal/tools/aws"
+	model "github.com/mattermost/mattermost-apps/model"
+	apps "github.com/mattermost/mattermost-plugin-apps/upstream/upaws"
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+// deploymentTransaction tracks the changes of a transactional deployment, enabled by setting
+// TransactionalDeployment to true. The static assets of the app version are staged and the lambda
+// functions applied before the manifest is published, and the changes are reverted if any of them
+// fails. Transactions are not used in dry run mode, where the stages which change the
+// deployment are skipped.
+type deploymentTransaction struct {
+	enabled   bool
+	committed bool
+	// stagedAssets are the static assets created by the deployment. Assets which existed before,
+	// when an app version is deployed again, are left in place on failure.
+	stagedAssets []string
+}
+
+func newDeploymentTransaction(dryRun bool) *deploymentTransaction {
+	return &deploymentTransaction{enabled: !dryRun && os.Getenv("TransactionalDeployment") == "true"}
+}
+
+// stage records the static assets which do not exist yet in the static bucket, before they are
+// uploaded.
+func (t *deploymentTransaction) stage(staticFiles map[string]apps.AssetData) error {
+	if !t.enabled {
+		return nil
+	}
+
+	for _, asset := range staticFiles {
+		exists, err := awsTools.StaticObjectExists(asset.Key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to check if static asset %s exists", asset.Key)
+		}
+		if !exists {
+			t.stagedAssets = append(t.stagedAssets, asset.Key)
+		}
+	}
+
+	return nil
+}
+
+// commit records that the manifest was published, after which the deployment is not reverted.
+func (t *deploymentTransaction) commit() {
+	t.committed = true
+}
+
+// pending returns true if the transaction changes are reverted on failure.
+func (t *deploymentTransaction) pending() bool {
+	return t.enabled && !t.committed
+}
+
+// compensate reverts the changes of the failed deployment. The aliases of the lambda functions
+// deployed by the previous app version are moved back to the version they pointed at, the lambda
+// functions new to the app are destroyed, and the staged static assets are removed.
+func (t *deploymentTransaction) compensate(ctx context.Context, deployment *model.Deployment, bundleName string, logger appsutils.Logger) error {
+	var failures []string
+	if deployment != nil {
+		appID := string(deployment.DeployData.Manifest.AppID)
+		record, err := awsTools.GetDeploymentRecord(os.Getenv("TerraformStateBucket"), os.Getenv("Environment"), appID)
+		if err != nil {
+			return errors.Wrap(err, "failed to get the previous deployment record")
+		}
+
+		existing := map[string]bool{}
+		if record != nil {
+			for _, function := range record.Lambdas {
+				existing[function.Name] = true
+			}
+		}
+		released := map[string]model.LambdaResult{}
+		for _, result := range deployment.Lambdas {
+			released[result.Name] = result
+		}
+
+		for _, function := range getFunctions(deployment, bundleName) {
+			functionLogger := logger.With("lambda_name", function.Name)
+			if !existing[function.Name] {
+				functionLogger.Infof("Destroying lambda function new to the app")
+				err = destroyLambda(ctx, deployment, function, functionLogger)
+				if err != nil {
+					failures = append(failures, errors.Wrapf(err, "failed to destroy lambda function %s", function.Name).Error())
+				}
+				continue
+			}
+
+			result, ok := released[function.Name]
+			if !ok || result.PreviousVersion == "" || result.PreviousVersion == result.Version {
+				continue
+			}
+			functionLogger.Infof("Reverting lambda alias %s to version %s", result.Alias, result.PreviousVersion)
+			err = awsTools.UpdateLambdaAlias(result.Name, result.Alias, result.PreviousVersion, "", 0)
+			if err != nil {
+				failures = append(failures, errors.Wrapf(err, "failed to revert lambda alias of %s", function.Name).Error())
+			}
+		}
+	}
+
+	if len(t.stagedAssets) > 0 {
+		logger.Infof("Removing %d staged static assets", len(t.stagedAssets))
+		err := awsTools.DeleteStaticFiles(t.stagedAssets, logger)
+		if err != nil {
+			failures = append(failures, errors.Wrap(err, "failed to remove staged static assets").Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	if deployment != nil {
+		deployment.Compensated = true
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	appsutils "github.com/mattermost/mattermost-plugin-apps/utils"
+)
+
+func TestDeploymentTransaction(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		transaction := newDeploymentTransaction(false)
+		assert.False(t, transaction.pending())
+		require.NoError(t, transaction.stage(nil))
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		t.Setenv("TransactionalDeployment", "true")
+		transaction := newDeploymentTransaction(true)
+		assert.False(t, transaction.pending())
+	})
+
+	t.Run("committed", func(t *testing.T) {
+		t.Setenv("TransactionalDeployment", "true")
+		transaction := newDeploymentTransaction(false)
+		assert.True(t, transaction.pending())
+
+		transaction.commit()
+		assert.False(t, transaction.pending())
+	})
+
+	t.Run("nothing to revert", func(t *testing.T) {
+		t.Setenv("TransactionalDeployment", "true")
+		transaction := newDeploymentTransaction(false)
+		assert.NoError(t, transaction.compensate(context.Background(), nil, "hello-world", appsutils.NewTestLogger()))
+	})
+}